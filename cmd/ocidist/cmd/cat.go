@@ -0,0 +1,61 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// catCmd represents the cat command
+var catCmd = &cobra.Command{
+	Use:   "cat <URL> <path>...",
+	Args:  cobra.MinimumNArgs(2),
+	Short: "print files of an image without pulling it",
+	Long: `
+Print files of the merged filesystem, following symlinks and hardlinks.  Only
+the layers down to the one holding each file are read:
+
+$ ocidist cat ocidist://localhost:5000/myrepo/myimage:v2.1 /etc/os-release
+`,
+	RunE:    doCat,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doCat(cmd *cobra.Command, args []string) error {
+	img, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, name := range args[1:] {
+		if _, err := img.ReadFile(name, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+	catCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	catCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	catCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to read, the host's by default")
+}
@@ -16,9 +16,15 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/raharper/ocidist/pkg/api"
 	"github.com/raharper/ocidist/pkg/image"
+	"github.com/raharper/ocidist/pkg/progress"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/spf13/cobra"
 )
 
@@ -31,6 +37,47 @@ var copyCmd = &cobra.Command{
 $ ocidist copy ocidist://localhost:5000/myrepo/myimage:v2.1 oci:///ocidir/myrepo/myimage:v2.1
 ...
 OK
+$ ocidist copy oci:///ocidir/myrepo/myimage:v2.1 oci-archive:///tmp/myimage.tar:v2.1
+$ ocidist copy docker-archive:///tmp/vendor.tar:vendor/app:1.0 oci:///ocidir/vendor/app:1.0
+
+--native copies through ocidist itself instead of containers/image, keeping
+manifests byte-for-byte.  --referrers also copies signatures and other
+artifacts attached to the image, and implies --native:
+
+$ ocidist copy --referrers ocidist://localhost:5000/soci/mysvc:1.0 oci:///ocidir:soci/mysvc:1.0
+
+A multi-platform image copies only the host platform's image unless --all or
+--platform is given.  --platform implies --native, which writes the selected
+images and then an index of only them, containers/image would push the
+source index referencing images that were not copied.  The new index has a
+new digest, so with --referrers a source index that has referrers is refused:
+
+$ ocidist copy --platform linux/arm64,linux/amd64 ocidist://localhost:5000/myimage:v2.1 oci:///ocidir:myimage:v2.1
+
+--policy enforces a containers-policy.json, e.g. signedBy or sigstoreSigned
+requirements, and refuses sources it rejects.  Without it the config file's
+'policy', or that of the source's entry under 'registries', applies:
+
+$ ocidist copy --policy /etc/containers/policy.json ocidist://build:5000/myrepo/app:1.2 ocidist://prod:5000/myrepo/app:1.2
+
+--dest-compress recompresses layers as gzip or zstd, writing new manifest
+digests.  containers/image cannot write uncompressed layers, so
+--dest-compress none implies --native, which writes docker manifests as OCI
+manifests.  --preserve-digests fails instead of changing any digest:
+
+$ ocidist copy --dest-compress zstd --compression-level 19 oci:///ocidir:myimage:v2.1 ocidist://localhost:5000/myimage:v2.1-zstd
+
+--progress json writes one JSON event per line instead of the usual report,
+as each blob starts, transfers, finishes or is skipped, as manifests and
+referrers are pushed, and finally done or error:
+
+$ ocidist copy --progress json --referrers oci:///ocidir:myimage:v2.1 ocidist://localhost:5000/myimage:v2.1
+{"time":"...","event":"blob-start","digest":"sha256:...","mediaType":"...","size":2107}
+
+--dry-run writes nothing, it lists the blobs missing at the destination, the
+bytes to send and each manifest or tag that would be created or overwritten:
+
+$ ocidist copy --dry-run --all ocidist://build:5000/myimage:v2.1 ocidist://edge:5000/myimage:v2.1
 `,
 	RunE:    doCopy,
 	PreRunE: doBeforeRunCmd,
@@ -45,9 +92,157 @@ func doCopy(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	native, err := cmd.Flags().GetBool("native")
+	if err != nil {
+		return err
+	}
+
+	referrers, err := cmd.Flags().GetBool("referrers")
+	if err != nil {
+		return err
+	}
+
+	artifactTypes, err := cmd.Flags().GetStringSlice("artifact-type")
+	if err != nil {
+		return err
+	}
+
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	platformList, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		return err
+	}
+
+	platforms, err := image.ParsePlatforms(platformList)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := cmd.Flags().GetInt("jobs")
+	if err != nil {
+		return err
+	}
+
+	if all && len(platforms) > 0 {
+		return fmt.Errorf("--all and --platform are mutually exclusive")
+	}
+
+	destCompress, err := cmd.Flags().GetString("dest-compress")
+	if err != nil {
+		return err
+	}
+
+	var compressionLevel *int
+	if cmd.Flags().Changed("compression-level") {
+		level, err := cmd.Flags().GetInt("compression-level")
+		if err != nil {
+			return err
+		}
+		compressionLevel = &level
+	}
+
+	preserveDigests, err := cmd.Flags().GetBool("preserve-digests")
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	progressMode, err := cmd.Flags().GetString("progress")
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput(cmd) && !dryRun {
+		return fmt.Errorf("--output and --format print the --dry-run plan, use --progress json to follow a copy")
+	}
+
+	var reporter progress.Reporter
+	var progressWriter io.Writer = os.Stdout
+	switch progressMode {
+	case "text":
+	case "json":
+		reporter = progress.NewJSONReporter(os.Stdout)
+		progressWriter = io.Discard
+	default:
+		return fmt.Errorf("Unknown --progress '%s', must be text or json", progressMode)
+	}
+
+	policy, err := policyPath(cmd, rawSrc)
+	if err != nil {
+		return err
+	}
+
+	if native || referrers || len(artifactTypes) > 0 || len(platforms) > 0 || destCompress == "none" || dryRun {
+		apiConfig := &api.OCIAPIConfig{TLSVerify: tlsVerify}
+		srcApi, err := api.NewOCIAPI(rawSrc, apiConfig)
+		if err != nil {
+			return err
+		}
+
+		destApi, err := api.NewOCIAPI(rawDest, apiConfig)
+		if err != nil {
+			return err
+		}
+
+		// resolve the tag once, the policy is checked for the digest copied
+		_, content, err := srcApi.GetManifestBytes(srcApi.RepoTag())
+		if err != nil {
+			return fmt.Errorf("Failed to get source manifest: %s", err)
+		}
+		srcDigest := digest.FromBytes(content)
+
+		policyOpts := image.ImageCopyOpts{SrcSkipTLS: !tlsVerify, PolicyPath: policy}
+		if err := api.CheckPolicy(rawSrc, srcDigest, policyOpts); err != nil {
+			return err
+		}
+
+		nativeOpts := api.NativeCopyOpts{
+			Referrers:        referrers || len(artifactTypes) > 0,
+			ArtifactTypes:    artifactTypes,
+			All:              all,
+			Platforms:        platforms,
+			Jobs:             jobs,
+			Compression:      destCompress,
+			CompressionLevel: compressionLevel,
+			PreserveDigests:  preserveDigests,
+			SourceDigest:     srcDigest,
+			Progress:         progressWriter,
+			Reporter:         reporter,
+		}
+		if dryRun {
+			nativeOpts.Progress = nil
+			plan, err := api.PlanNativeCopy(srcApi, destApi, nativeOpts)
+			if err != nil {
+				return err
+			}
+			return printOutput(cmd, plan, func(w io.Writer) error {
+				plan.Print(w)
+				return nil
+			})
+		}
+		return api.NativeCopy(srcApi, destApi, nativeOpts)
+	}
+
 	copyOpts := image.ImageCopyOpts{
-		SrcSkipTLS:  !tlsVerify,
-		DestSkipTLS: !tlsVerify,
+		SrcSkipTLS:       !tlsVerify,
+		DestSkipTLS:      !tlsVerify,
+		All:              all,
+		Platforms:        platforms,
+		Jobs:             jobs,
+		PolicyPath:       policy,
+		DestCompression:  destCompress,
+		CompressionLevel: compressionLevel,
+		PreserveDigests:  preserveDigests,
+		Progress:         progressWriter,
+		Reporter:         reporter,
 	}
 
 	if err := api.ImageCopy(rawSrc, rawDest, copyOpts); err != nil {
@@ -61,4 +256,17 @@ func init() {
 	rootCmd.AddCommand(copyCmd)
 	copyCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
 	copyCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	copyCmd.PersistentFlags().Bool("native", false, "copy with ocidist instead of containers/image")
+	copyCmd.PersistentFlags().Bool("referrers", false, "also copy referrers of copied manifests, implies --native")
+	copyCmd.PersistentFlags().Bool("all", false, "copy every image of a multi-platform index")
+	copyCmd.PersistentFlags().String("platform", "", "copy only these comma separated os/arch[/variant] platforms of an index, implies --native")
+	copyCmd.PersistentFlags().IntP("jobs", "j", 0, "concurrent blob transfers per registry, 0 for the default")
+	copyCmd.PersistentFlags().String("dest-compress", "", "recompress layers as zstd, gzip or none")
+	copyCmd.PersistentFlags().Int("compression-level", 0, "compression level for --dest-compress, the algorithm default if unset")
+	copyCmd.PersistentFlags().Bool("preserve-digests", false, "fail instead of changing any manifest digest")
+	copyCmd.PersistentFlags().String("progress", "text", "progress output, text or json events one per line")
+	copyCmd.PersistentFlags().Bool("dry-run", false, "only report the blobs and manifests that would be written, implies --native")
+	copyCmd.PersistentFlags().String("policy", "", "containers-policy.json the source must satisfy")
+	copyCmd.PersistentFlags().StringSlice("artifact-type", []string{}, "only copy referrers with these artifact types, implies --referrers")
+	addOutputFlags(copyCmd)
 }
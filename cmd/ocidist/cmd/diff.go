@@ -0,0 +1,120 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/image"
+	"github.com/raharper/ocidist/pkg/layer"
+
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <URL> <URL>",
+	Args:  cobra.ExactArgs(2),
+	Short: "print what changed between two images",
+	Long: `
+Compare the configs and layers of two images, and with --files their merged
+filesystems, whiteouts applied:
+
+$ ocidist diff --files ocidist://localhost:5000/myrepo/myimage:v1.4 ocidist://localhost:5000/myrepo/myimage:v1.5
+Diff ocidist://localhost:5000/myrepo/myimage:v1.4 sha256:...
+  to ocidist://localhost:5000/myrepo/myimage:v1.5 sha256:...
+Config:
+  ~ Env VERSION: 1.4 -> 1.5
+  + History 3: ADD app
+Layers:
+  = sha256:... 2.1MiB
+  + sha256:... 12KiB
+Files:
+  ~ -rwxr-xr-x 10KiB /usr/bin/app (was -rwxr-xr-x 9KiB)
+  - -rw-r--r-- 12B /etc/app/old.conf
+`,
+	RunE:    doDiff,
+	PreRunE: doBeforeRunCmd,
+}
+
+// openImage opens the image at rawURL, of an index the image for --platform
+// or the host platform.
+func openImage(cmd *cobra.Command, rawURL string) (*layer.Image, error) {
+	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
+	if err != nil {
+		return nil, err
+	}
+
+	platformFlag, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		return nil, err
+	}
+	platform := api.HostPlatform()
+	if platformFlag != "" {
+		platforms, err := image.ParsePlatforms(platformFlag)
+		if err != nil {
+			return nil, err
+		}
+		if len(platforms) != 1 {
+			return nil, fmt.Errorf("--platform takes a single os/arch[/variant]")
+		}
+		platform = platforms[0]
+	}
+
+	config := &api.OCIAPIConfig{TLSVerify: tlsVerify}
+	ociApi, err := api.NewOCIAPI(rawURL, config)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Open(ociApi, ociApi.RepoTag(), platform)
+}
+
+func doDiff(cmd *cobra.Command, args []string) error {
+	files, err := cmd.Flags().GetBool("files")
+	if err != nil {
+		return err
+	}
+
+	from, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	to, err := openImage(cmd, args[1])
+	if err != nil {
+		return err
+	}
+
+	diff, err := layer.DiffImages(from, to, files)
+	if err != nil {
+		return fmt.Errorf("Failed to diff images: %s", err)
+	}
+
+	return printOutput(cmd, diff, func(w io.Writer) error {
+		diff.Print(w)
+		return nil
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	diffCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	diffCmd.PersistentFlags().Bool("files", false, "also compare the files of the merged filesystems")
+	diffCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to compare, the host's by default")
+	addOutputFlags(diffCmd)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	"github.com/spf13/cobra"
+)
+
+// duCmd represents the du command
+var duCmd = &cobra.Command{
+	Use:   "du <URL>",
+	Args:  cobra.ExactArgs(1),
+	Short: "report the size of every tag of a repository or layout",
+	Long: `
+Walk the manifests of every tag and report how many bytes each tag uses, how
+many of those no other tag uses, which deleting the tag would free, and how
+many it shares.  The total counts every blob once:
+
+$ ocidist du ocidist://localhost:5000/myrepo/myimage
+Usage of ocidist://localhost:5000/myrepo/myimage
+  v2.0                    52.1MiB total    1.2MiB unique    50.9MiB shared  4 blobs
+  v2.1                    53.4MiB total    2.5MiB unique    50.9MiB shared  4 blobs
+2 tags, 6 blobs, 54.6MiB stored, 105.5MiB without sharing
+$ ocidist du oci:///ocidir
+`,
+	RunE:    doDu,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doDu(cmd *cobra.Command, args []string) error {
+	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
+	if err != nil {
+		return err
+	}
+
+	report, err := api.DiskUsage(args[0], &api.OCIAPIConfig{TLSVerify: tlsVerify})
+	if err != nil {
+		return err
+	}
+
+	return printOutput(cmd, report, func(w io.Writer) error {
+		report.Print(w)
+		return nil
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+	duCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	duCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	addOutputFlags(duCmd)
+}
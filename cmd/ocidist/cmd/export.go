@@ -0,0 +1,82 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <URL> <file|->",
+	Args:  cobra.ExactArgs(2),
+	Short: "write the root filesystem of an image as one tarball",
+	Long: `
+Flatten the layers of the image into a single tar with whiteouts applied,
+keeping owners, modes, hardlinks and xattrs, to a file or with - to stdout:
+
+$ ocidist export ocidist://localhost:5000/myrepo/myimage:v2.1 rootfs.tar
+$ ocidist export oci:///ocidir:myimage:v2.1 - | tar -tv
+`,
+	RunE:    doExport,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doExport(cmd *cobra.Command, args []string) error {
+	img, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if args[1] != "-" {
+		if file, err = os.Create(args[1]); err != nil {
+			return fmt.Errorf("Failed to create '%s': %s", args[1], err)
+		}
+		out = file
+	}
+
+	buffered := bufio.NewWriter(out)
+	err = img.Export(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(args[1])
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to export '%s': %s", args[0], err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	exportCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	exportCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to export, the host's by default")
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// extractCmd represents the extract command
+var extractCmd = &cobra.Command{
+	Use:   "extract <URL> <path> <dest>",
+	Args:  cobra.ExactArgs(3),
+	Short: "extract a file or directory of an image without pulling it",
+	Long: `
+Write a file or directory tree of the merged filesystem to dest, reading only
+the layers that hold part of it.  A directory's contents are written into
+dest, a file is written as dest or into dest if it is a directory.  Nothing is
+written outside of dest or through symlinks, and owners are not kept:
+
+$ ocidist extract ocidist://localhost:5000/myrepo/myimage:v2.1 /opt/app ./out
+$ ocidist extract oci:///ocidir:myrepo/myimage:v2.1 /etc/app.conf .
+`,
+	RunE:    doExtract,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doExtract(cmd *cobra.Command, args []string) error {
+	img, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := img.Extract(args[1], args[2]); err != nil {
+		return fmt.Errorf("Failed to extract '%s': %s", args[1], err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+	extractCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	extractCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	extractCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to extract from, the host's by default")
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <URL>",
+	Args:  cobra.ExactArgs(1),
+	Short: "print the history of an image with the layers it created",
+	Long: `
+Print the image's history newest first, each step with the layer it created,
+as docker history does but without a daemon:
+
+$ ocidist history ocidist://localhost:5000/myrepo/myimage:v2.1
+LAYER         CREATED       CREATED BY                                     SIZE    COMMENT
+a5b0cf781f8f  2 weeks ago   ADD app v2                                     10KiB
+-             2 months ago  ENV V=1                                        0B
+201da2af2143  2 months ago  ADD base                                       422B
+$ ocidist history --no-trunc --output json oci:///ocidir:myimage:v2.1
+`,
+	RunE:    doHistory,
+	PreRunE: doBeforeRunCmd,
+}
+
+// truncate shortens s to n characters, unless noTrunc
+func truncate(s string, n int, noTrunc bool) string {
+	runes := []rune(s)
+	if noTrunc || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+func doHistory(cmd *cobra.Command, args []string) error {
+	noTrunc, err := cmd.Flags().GetBool("no-trunc")
+	if err != nil {
+		return err
+	}
+
+	img, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	// newest first
+	history := img.History()
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	return printOutput(cmd, history, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "LAYER\tCREATED\tCREATED BY\tSIZE\tCOMMENT")
+		for _, h := range history {
+			layer := "-"
+			if h.Layer != "" {
+				layer = h.Layer.String()
+				if !noTrunc {
+					layer = h.Layer.Encoded()[:12]
+				}
+			}
+			created := "-"
+			if h.Created != nil {
+				created = units.HumanDuration(time.Since(*h.Created)) + " ago"
+			}
+			createdBy := strings.Join(strings.Fields(h.CreatedBy), " ")
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", layer, created, truncate(createdBy, 45, noTrunc),
+				units.BytesSize(float64(h.Size)), h.Comment)
+		}
+		return tw.Flush()
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	historyCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	historyCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to show, the host's by default")
+	historyCmd.PersistentFlags().Bool("no-trunc", false, "do not truncate layer digests and commands")
+	addOutputFlags(historyCmd)
+}
@@ -17,7 +17,7 @@ package cmd
 
 import (
 	"fmt"
-	"strings"
+	"io"
 
 	"github.com/raharper/ocidist/pkg/api"
 
@@ -33,11 +33,25 @@ var imagesCmd = &cobra.Command{
 $ ocidist images ocidist://localhost:5000/myrepo/myimage
 myimage:v1
 myimage:v2
+
+$ ocidist images --output table ocidist://localhost:5000/myrepo/myimage
+NAME            TAG
+myrepo/myimage  v1
+myrepo/myimage  v2
+
+$ ocidist images --format '{{.Tag}}' ocidist://localhost:5000/myrepo/myimage
+v1
+v2
 `,
 	RunE:    doImages,
 	PreRunE: doBeforeRunCmd,
 }
 
+type ImageOutput struct {
+	Name string
+	Tag  string
+}
+
 func doImages(cmd *cobra.Command, args []string) error {
 	rawURL := args[0]
 
@@ -57,25 +71,26 @@ func doImages(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	var imageName string
-	if !tagsOnly {
-		imageName = ociApi.RepoPath()
-	}
-
 	tags, err := ociApi.GetRepoTags()
 	if err != nil {
 		return err
 	}
 
-	// fmt.Printf("URL=%s repo:\n", ociApi.RepoPath())
+	images := []ImageOutput{}
 	for _, tag := range tags {
-		if tagsOnly {
-			fmt.Printf("%s\n", tag)
-		} else {
-			fmt.Printf("%s\n", strings.Join([]string{imageName, tag}, "/"))
-		}
+		images = append(images, ImageOutput{Name: ociApi.RepoPath(), Tag: tag})
 	}
-	return nil
+
+	return printOutput(cmd, images, func(w io.Writer) error {
+		for _, image := range images {
+			if tagsOnly {
+				fmt.Fprintf(w, "%s\n", image.Tag)
+			} else {
+				fmt.Fprintf(w, "%s/%s\n", image.Name, image.Tag)
+			}
+		}
+		return nil
+	})
 }
 
 func init() {
@@ -83,4 +98,5 @@ func init() {
 	imagesCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
 	imagesCmd.PersistentFlags().BoolP("tags-only", "t", false, "print image tags only")
 	imagesCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	addOutputFlags(imagesCmd)
 }
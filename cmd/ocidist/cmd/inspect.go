@@ -16,16 +16,19 @@ limitations under the License.
 package cmd
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/raharper/ocidist/pkg/api"
 
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -43,12 +46,26 @@ $ ocidist inspect ocidist://localhost:5000/myrepo/myimage:v2.1
     "v2.1"
   ],
   ...
-}`,
+}
+
+--raw prints the manifest, or index of a multi-platform image, exactly as
+stored.  --config prints the image config, of the host platform's image of an
+index, and with --raw exactly as stored:
+
+$ ocidist inspect --raw oci:///ocidir:myrepo/myimage:v2.1 | sha256sum
+$ ocidist inspect --config --raw oci-archive:///tmp/myimage.tar:v2.1
+
+--output and --format apply to the inspect output or, with --config, to the
+config:
+
+$ ocidist inspect --format '{{.Digest}} {{join .RepoTags ","}}' oci:///ocidir:myrepo/myimage:v2.1
+`,
 	RunE:    doInspect,
 	PreRunE: doBeforeRunCmd,
 }
 
 // github.com/containers/skopeo/cmd/skopeo/inspect/output.go:Output
+// extended with the OCI fields skopeo does not report
 type InspectOutput struct {
 	Name          string `json:",omitempty"`
 	Tag           string `json:",omitempty"`
@@ -62,22 +79,40 @@ type InspectOutput struct {
 	Layers        []string
 	LayersData    []types.ImageInspectLayer `json:",omitempty"`
 	Env           []string
+
+	MediaType string
+	// the image manifest's, when Digest is that of an index
+	ManifestDigest    digest.Digest     `json:",omitempty"`
+	ArtifactType      string            `json:",omitempty"`
+	Annotations       map[string]string `json:",omitempty"`
+	ConfigMediaType   string
+	ConfigAnnotations map[string]string `json:",omitempty"`
+	// compressed size of all layers
+	Size      int64
+	Subject   *ispec.Descriptor `json:",omitempty"`
+	Referrers []InspectReferrer `json:",omitempty"`
+}
+
+type InspectReferrer struct {
+	Digest       digest.Digest
+	ArtifactType string `json:",omitempty"`
+	MediaType    string
+	Size         int64
+	Annotations  map[string]string `json:",omitempty"`
 }
 
 func doInspect(cmd *cobra.Command, args []string) error {
 	rawURL := args[0]
 
-	/*
-		outputConfig, err := cmd.Flags().GetBool("config")
-		if err != nil {
-			return err
-		}
+	outputConfig, err := cmd.Flags().GetBool("config")
+	if err != nil {
+		return err
+	}
 
-		rawOutput, err := cmd.Flags().GetBool("raw")
-		if err != nil {
-			return err
-		}
-	*/
+	rawOutput, err := cmd.Flags().GetBool("raw")
+	if err != nil {
+		return err
+	}
 
 	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
 	if err != nil {
@@ -90,55 +125,163 @@ func doInspect(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// FIXME; use GetManifestWithDigest()
-	manifest, manifestBytes, err := ociApi.GetManifest()
+	if rawOutput && structuredOutput(cmd) {
+		return fmt.Errorf("--raw is exactly as stored, it cannot be used with --output or --format")
+	}
+
+	if outputConfig {
+		return inspectConfig(cmd, ociApi, rawOutput)
+	}
+
+	if rawOutput {
+		_, content, err := ociApi.GetManifestBytes(ociApi.RepoTag())
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(content)
+		return nil
+	}
+
+	mediaType, content, err := ociApi.GetManifestBytes(ociApi.RepoTag())
 	if err != nil {
 		return err
 	}
+	top := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
 
-	// compute manifest digest
-	hash := sha256.New()
-	hash.Write(manifestBytes)
-	manifestDigest := digest.Digest(fmt.Sprintf("sha256:%s", hex.EncodeToString(hash.Sum(nil))))
-
-	img, err := ociApi.GetImage(&manifest.Config)
+	desc, manifestBytes, err := api.ResolveManifest(ociApi, top.Digest.String(), api.HostPlatform())
 	if err != nil {
 		return err
 	}
 
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return fmt.Errorf("Failed to parse manifest '%s': %s", desc.Digest, err)
+	}
+
+	configBytes, err := ociApi.GetBlob(&manifest.Config)
+	if err != nil {
+		return fmt.Errorf("Failed to get config '%s': %s", manifest.Config.Digest, err)
+	}
+
+	// docker_version is only in docker image configs
+	var img struct {
+		ispec.Image
+		DockerVersion string `json:"docker_version"`
+	}
+	if err := json.Unmarshal(configBytes, &img); err != nil {
+		return fmt.Errorf("Failed to parse config '%s': %s", manifest.Config.Digest, err)
+	}
+
 	tagList, err := ociApi.GetRepoTagList()
 	if err != nil {
 		return err
 	}
 
-	var layers []string
-	for _, desc := range manifest.Layers {
-		layers = append(layers, string(desc.Digest))
+	output := InspectOutput{
+		Name:              ociApi.ImageName(),
+		Digest:            top.Digest,
+		RepoTags:          tagList.Tags,
+		Created:           img.Created,
+		DockerVersion:     img.DockerVersion,
+		Labels:            img.Config.Labels,
+		Architecture:      img.Architecture,
+		Os:                img.OS,
+		Layers:            []string{},
+		LayersData:        []types.ImageInspectLayer{},
+		Env:               img.Config.Env,
+		MediaType:         top.MediaType,
+		ArtifactType:      manifest.ArtifactType,
+		Annotations:       manifest.Annotations,
+		ConfigMediaType:   manifest.Config.MediaType,
+		ConfigAnnotations: manifest.Config.Annotations,
+		Subject:           manifest.Subject,
 	}
 
-	output := InspectOutput{
-		Digest:       manifestDigest,
-		RepoTags:     tagList.Tags,
-		Created:      img.Created,
-		Labels:       img.Config.Labels,
-		Architecture: img.Architecture,
-		Os:           img.OS,
-		Layers:       layers,
-		Env:          img.Config.Env,
+	if _, err := digest.Parse(ociApi.RepoTag()); err != nil {
+		output.Tag = ociApi.RepoTag()
+	}
+
+	if desc.Digest != top.Digest {
+		output.ManifestDigest = desc.Digest
 	}
 
-	if ociApi.Type() == api.OCIDistRepoType {
-		output.Name = ociApi.ImageName()
+	for _, layer := range manifest.Layers {
+		output.Layers = append(output.Layers, string(layer.Digest))
+		output.LayersData = append(output.LayersData, types.ImageInspectLayer{
+			MIMEType:    layer.MediaType,
+			Digest:      layer.Digest,
+			Size:        layer.Size,
+			Annotations: layer.Annotations,
+		})
+		output.Size += layer.Size
+	}
+
+	// not every registry or backend implements referrers
+	referrers, err := ociApi.GetReferrers(&top)
+	if err != nil {
+		log.Debugf("inspect: no referrers of %s: %s", top.Digest, err)
+	} else {
+		for _, referrer := range referrers.Manifests {
+			output.Referrers = append(output.Referrers, InspectReferrer{
+				Digest:       referrer.Digest,
+				ArtifactType: referrer.ArtifactType,
+				MediaType:    referrer.MediaType,
+				Size:         referrer.Size,
+				Annotations:  referrer.Annotations,
+			})
+		}
 	}
 
-	outputBytes, err := json.MarshalIndent(output, "", "    ")
+	return printOutput(cmd, output, func(w io.Writer) error {
+		outputBytes, err := json.MarshalIndent(output, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", outputBytes)
+		return nil
+	})
+}
+
+// inspectConfig prints the config of the image, the host platform's image of
+// an index, indented or exactly as stored if raw.
+func inspectConfig(cmd *cobra.Command, ociApi api.OCIAPI, raw bool) error {
+	_, content, err := api.ResolveManifest(ociApi, ociApi.RepoTag(), api.HostPlatform())
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("%s\n", outputBytes)
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return fmt.Errorf("Failed to parse manifest: %s", err)
+	}
 
-	return nil
+	config, err := ociApi.GetBlob(&manifest.Config)
+	if err != nil {
+		return fmt.Errorf("Failed to get config '%s': %s", manifest.Config.Digest, err)
+	}
+
+	if raw {
+		os.Stdout.Write(config)
+		return nil
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return fmt.Errorf("Failed to parse config '%s': %s", manifest.Config.Digest, err)
+	}
+
+	return printOutput(cmd, parsed, func(w io.Writer) error {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, config, "", "    "); err != nil {
+			return fmt.Errorf("Failed to parse config '%s': %s", manifest.Config.Digest, err)
+		}
+		fmt.Fprintf(w, "%s\n", indented.Bytes())
+		return nil
+	})
 }
 
 func init() {
@@ -147,4 +290,5 @@ func init() {
 	inspectCmd.PersistentFlags().BoolP("config", "c", false, "output configuration")
 	inspectCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
 	inspectCmd.PersistentFlags().BoolP("raw", "r", false, "output raw manifest or configuration")
+	addOutputFlags(inspectCmd)
 }
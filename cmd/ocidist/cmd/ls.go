@@ -0,0 +1,182 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raharper/ocidist/pkg/layer"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls <URL> [path-glob]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "list the files of an image without unpacking it",
+	Long: `
+List the merged filesystem of an image, whiteouts applied, or with --layer
+the entries of a single layer, whiteouts included.  A glob lists the matching
+paths and everything under them:
+
+$ ocidist ls ocidist://localhost:5000/myrepo/myimage:v2.1 '/etc/*.conf'
+-rw-r--r--  root/root  1.2KiB  2023-11-14 22:13  1  /etc/app.conf
+$ ocidist ls --layer 0 oci:///ocidir:myrepo/myimage:v2.1 /usr/bin
+`,
+	RunE:    doLs,
+	PreRunE: doBeforeRunCmd,
+}
+
+type LsEntry struct {
+	Path    string
+	Mode    string
+	Size    int64
+	Owner   string
+	Uid     int
+	Gid     int
+	ModTime time.Time
+	Link    string `json:",omitempty"`
+	// index of the layer the entry comes from
+	Layer       int
+	LayerDigest digest.Digest
+	Whiteout    bool `json:",omitempty"`
+}
+
+// globMatches reports if glob matches name or one of its parent directories
+func globMatches(glob, name string) (bool, error) {
+	if glob == "" {
+		return true, nil
+	}
+	for ; ; name = path.Dir(name) {
+		matched, err := path.Match(glob, name)
+		if err != nil {
+			return false, fmt.Errorf("Invalid path glob '%s': %s", glob, err)
+		}
+		if matched {
+			return true, nil
+		}
+		if name == "/" {
+			return false, nil
+		}
+	}
+}
+
+func newLsEntry(img *layer.Image, name string, hdr *tar.Header, n int) LsEntry {
+	owner := fmt.Sprintf("%d/%d", hdr.Uid, hdr.Gid)
+	if hdr.Uname != "" && hdr.Gname != "" {
+		owner = fmt.Sprintf("%s/%s", hdr.Uname, hdr.Gname)
+	}
+	// hardlinks name their target from the root, already cleaned in the
+	// merged view but as stored in the tar in a single layer
+	link := hdr.Linkname
+	if hdr.Typeflag == tar.TypeLink {
+		link = layer.CleanPath(link)
+	}
+	return LsEntry{
+		Path:        name,
+		Mode:        layer.Mode(hdr),
+		Size:        hdr.Size,
+		Owner:       owner,
+		Uid:         hdr.Uid,
+		Gid:         hdr.Gid,
+		ModTime:     hdr.ModTime.UTC(),
+		Link:        link,
+		Layer:       n,
+		LayerDigest: img.Manifest.Layers[n].Digest,
+		Whiteout:    layer.IsWhiteout(name),
+	}
+}
+
+func doLs(cmd *cobra.Command, args []string) error {
+	glob := ""
+	if len(args) > 1 {
+		glob = layer.CleanPath(args[1])
+	}
+	if _, err := globMatches(glob, "/"); err != nil {
+		return err
+	}
+
+	layerIdx, err := cmd.Flags().GetInt("layer")
+	if err != nil {
+		return err
+	}
+
+	img, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	entries := []LsEntry{}
+	if cmd.Flags().Changed("layer") {
+		err = img.WalkLayer(layerIdx, func(name string, hdr *tar.Header, r io.Reader) error {
+			matched, err := globMatches(glob, name)
+			if matched {
+				entries = append(entries, newLsEntry(img, name, hdr, layerIdx))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		tree, err := img.Merge(false)
+		if err != nil {
+			return err
+		}
+		for _, entry := range tree.Entries() {
+			matched, err := globMatches(glob, entry.Path)
+			if err != nil {
+				return err
+			}
+			if matched {
+				entries = append(entries, newLsEntry(img, entry.Path, entry.Header, entry.Layer))
+			}
+		}
+	}
+
+	return printOutput(cmd, entries, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, e := range entries {
+			name := e.Path
+			if strings.HasPrefix(e.Mode, "l") {
+				name = fmt.Sprintf("%s -> %s", e.Path, e.Link)
+			} else if e.Link != "" {
+				name = fmt.Sprintf("%s link to %s", e.Path, e.Link)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", e.Mode, e.Owner, units.BytesSize(float64(e.Size)),
+				e.ModTime.Format("2006-01-02 15:04"), e.Layer, name)
+		}
+		return tw.Flush()
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+	lsCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	lsCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	lsCmd.PersistentFlags().IntP("layer", "l", 0, "list only the entries of this layer, counting from 0 at the bottom")
+	lsCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to list, the host's by default")
+	addOutputFlags(lsCmd)
+}
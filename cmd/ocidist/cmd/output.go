@@ -0,0 +1,296 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+// addOutputFlags adds the --output and --format flags read by printOutput
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("output", "o", outputText, "output as text, json, yaml or table")
+	cmd.PersistentFlags().String("format", "", "print each item with a Go template, e.g. '{{.Digest}}'")
+}
+
+// structuredOutput reports if --output or --format replace the command's
+// text output, for commands to keep progress off stdout.
+func structuredOutput(cmd *cobra.Command) bool {
+	output, _ := cmd.Flags().GetString("output")
+	format, _ := cmd.Flags().GetString("format")
+	return format != "" || (output != "" && output != outputText)
+}
+
+// printOutput writes v to stdout as --output asks, or each item of v, or v
+// itself if it is not a slice, through the --format template.  The text
+// output is the command's own, written by text.
+func printOutput(cmd *cobra.Command, v interface{}, text func(w io.Writer) error) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	if format != "" {
+		if cmd.Flags().Changed("output") {
+			return fmt.Errorf("--output and --format are mutually exclusive")
+		}
+		return printTemplate(os.Stdout, format, v)
+	}
+
+	switch output {
+	case outputText, "":
+		return text(os.Stdout)
+	case outputJSON:
+		content, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to marshal output: %s", err)
+		}
+		fmt.Printf("%s\n", content)
+		return nil
+	case outputYAML:
+		return printYAML(os.Stdout, v)
+	case outputTable:
+		return printTable(os.Stdout, v)
+	}
+	return fmt.Errorf("Unknown --output '%s', must be text, json, yaml or table", output)
+}
+
+func printTemplate(w io.Writer, format string, v interface{}) error {
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			content, err := json.Marshal(v)
+			return string(content), err
+		},
+		"join": strings.Join,
+	}
+	tmpl, err := template.New("format").Funcs(funcs).Parse(format)
+	if err != nil {
+		return fmt.Errorf("Invalid --format template: %s", err)
+	}
+
+	for _, item := range outputItems(v) {
+		if err := tmpl.Execute(w, item.Interface()); err != nil {
+			return fmt.Errorf("Failed to execute --format template: %s", err)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// printYAML writes v with the field names of its JSON output
+func printYAML(w io.Writer, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal output: %s", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(content, &generic); err != nil {
+		return fmt.Errorf("Failed to marshal output: %s", err)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(generic); err != nil {
+		return fmt.Errorf("Failed to marshal output: %s", err)
+	}
+	return encoder.Close()
+}
+
+// printTable writes a slice of structs as a row per item, and a single
+// struct or map as a row per field.
+func printTable(w io.Writer, v interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Struct {
+		fmt.Fprintln(tw, "FIELD\tVALUE")
+		for _, field := range tableFields(rv.Type()) {
+			fmt.Fprintf(tw, "%s\t%s\n", field.name, tableCell(rv.Field(field.index)))
+		}
+		return tw.Flush()
+	}
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		keys := []string{}
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		fmt.Fprintln(tw, "FIELD\tVALUE")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%s\t%s\n", key, tableCell(rv.MapIndex(reflect.ValueOf(key))))
+		}
+		return tw.Flush()
+	}
+
+	items := outputItems(v)
+	if len(items) == 0 {
+		return nil
+	}
+	elemType := reflect.Indirect(items[0]).Type()
+	if elemType.Kind() != reflect.Struct {
+		fmt.Fprintln(tw, "VALUE")
+		for _, item := range items {
+			fmt.Fprintln(tw, tableCell(item))
+		}
+		return tw.Flush()
+	}
+
+	fields := tableFields(elemType)
+	names := []string{}
+	for _, field := range fields {
+		names = append(names, field.name)
+	}
+	fmt.Fprintln(tw, strings.Join(names, "\t"))
+	for _, item := range items {
+		item = reflect.Indirect(item)
+		cells := []string{}
+		for _, field := range fields {
+			cells = append(cells, tableCell(item.Field(field.index)))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// outputItems returns the elements of a slice, or v alone
+func outputItems(v interface{}) []reflect.Value {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []reflect.Value{rv}
+	}
+	items := []reflect.Value{}
+	for i := 0; i < rv.Len(); i++ {
+		items = append(items, rv.Index(i))
+	}
+	return items
+}
+
+type tableField struct {
+	index int
+	name  string
+}
+
+// tableFields returns the exported fields of t, named as in the JSON output
+func tableFields(t reflect.Type) []tableField {
+	fields := []tableField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, tableField{index: i, name: strings.ToUpper(name)})
+	}
+	return fields
+}
+
+// tableCell formats scalars and lists of them as text, anything else as JSON
+func tableCell(v reflect.Value) string {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return ""
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok && v.Kind() != reflect.Ptr {
+		return s.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return tableCell(v.Elem())
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16,
+		reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	case reflect.Slice, reflect.Array:
+		cells := []string{}
+		for i := 0; i < v.Len(); i++ {
+			if !isScalar(v.Index(i)) {
+				return tableJSON(v)
+			}
+			cells = append(cells, tableCell(v.Index(i)))
+		}
+		return strings.Join(cells, ",")
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return tableJSON(v)
+		}
+		cells := []string{}
+		for _, key := range v.MapKeys() {
+			if !isScalar(v.MapIndex(key)) {
+				return tableJSON(v)
+			}
+			cells = append(cells, fmt.Sprintf("%s=%s", key.String(), tableCell(v.MapIndex(key))))
+		}
+		sort.Strings(cells)
+		return strings.Join(cells, ",")
+	}
+	return tableJSON(v)
+}
+
+// isScalar reports if v is text in a list of table cells
+func isScalar(v reflect.Value) bool {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		_, ok := v.Interface().(time.Time)
+		return ok
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return false
+	}
+	return true
+}
+
+func tableJSON(v reflect.Value) string {
+	content, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(content)
+}
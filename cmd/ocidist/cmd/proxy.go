@@ -0,0 +1,100 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/server"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy --upstream <URL> --cache <dir>",
+	Args:  cobra.NoArgs,
+	Short: "run a pull-through caching proxy for an upstream registry",
+	Long: `
+Blobs and manifests requested by digest are cached forever, manifests
+requested by tag are revalidated with the upstream registry after --ttl.
+
+$ ocidist proxy --upstream https://registry.example --cache /var/cache/ocidist --listen :5000
+$ ocidist inspect -T=false ocidist://localhost:5000/myrepo/myimage:v2.1
+`,
+	RunE:    doProxy,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doProxy(cmd *cobra.Command, args []string) error {
+	upstream, err := cmd.Flags().GetString("upstream")
+	if err != nil {
+		return err
+	}
+
+	cacheDir, err := cmd.Flags().GetString("cache")
+	if err != nil {
+		return err
+	}
+
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return err
+	}
+
+	ttl, err := cmd.Flags().GetDuration("ttl")
+	if err != nil {
+		return err
+	}
+
+	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
+	if err != nil {
+		return err
+	}
+
+	debug, err := cmd.Flags().GetBool("debug")
+	if err != nil {
+		return err
+	}
+
+	if upstream == "" {
+		return fmt.Errorf("--upstream is required")
+	}
+
+	config := &api.OCIAPIConfig{TLSVerify: tlsVerify, Debug: debug}
+	proxy, err := server.NewProxy(upstream, cacheDir, ttl, config)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+
+	log.Infof("Proxying %s with cache %q on %s", upstream, cacheDir, listen)
+	return http.ListenAndServe(listen, proxy)
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+	proxyCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	proxyCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification of the upstream registry")
+	proxyCmd.PersistentFlags().StringP("upstream", "u", "", "upstream registry URL")
+	proxyCmd.PersistentFlags().StringP("cache", "c", "/var/cache/ocidist", "cache directory")
+	proxyCmd.PersistentFlags().StringP("listen", "l", ":5000", "address to listen on")
+	proxyCmd.PersistentFlags().Duration("ttl", 5*time.Minute, "revalidate manifests fetched by tag after this long")
+}
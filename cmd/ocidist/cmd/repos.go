@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/raharper/ocidist/pkg/api"
 
@@ -30,11 +31,23 @@ var reposCmd = &cobra.Command{
 	Short: "Print a list of repositories available at URL",
 	Long: `
 $ ocidist repos ocidist://localhost:5000
+ myrepo/myimage
+
+$ ocidist repos --output json ocidist://localhost:5000
+[
+  {
+    "Name": "myrepo/myimage"
+  }
+]
 `,
 	RunE:    doRepos,
 	PreRunE: doBeforeRunCmd,
 }
 
+type RepoOutput struct {
+	Name string
+}
+
 func doRepos(cmd *cobra.Command, args []string) error {
 	rawURL := args[0]
 
@@ -54,14 +67,22 @@ func doRepos(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	output := []RepoOutput{}
 	for _, repo := range repos {
-		fmt.Printf(" %s\n", repo)
+		output = append(output, RepoOutput{Name: repo})
 	}
-	return nil
+
+	return printOutput(cmd, output, func(w io.Writer) error {
+		for _, repo := range output {
+			fmt.Fprintf(w, " %s\n", repo.Name)
+		}
+		return nil
+	})
 }
 
 func init() {
 	rootCmd.AddCommand(reposCmd)
 	reposCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
 	reposCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	addOutputFlags(reposCmd)
 }
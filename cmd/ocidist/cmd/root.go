@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/raharper/ocidist/pkg/api"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -88,6 +90,31 @@ func initConfig() {
 	}
 }
 
+// loadConfig decodes the settings shared by every command from the config
+// file, if any was found.
+func loadConfig() (*api.Config, error) {
+	var config api.Config
+	if err := viper.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("Failed to parse config %q: %s", viper.ConfigFileUsed(), err)
+	}
+	return &config, nil
+}
+
+// policyPath returns the --policy flag, or the config file's signature
+// policy for rawSrc.
+func policyPath(cmd *cobra.Command, rawSrc string) (string, error) {
+	path, err := cmd.Flags().GetString("policy")
+	if err != nil || path != "" {
+		return path, err
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.PolicyPath(rawSrc), nil
+}
+
 func doBeforeRunCmd(cmd *cobra.Command, args []string) error {
 	debug, err := cmd.Flags().GetBool("debug")
 	if err != nil {
@@ -0,0 +1,90 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/raharper/ocidist/pkg/server"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve --root <dir> --listen <addr>",
+	Args:  cobra.NoArgs,
+	Short: "serve a directory of OCI layouts as a distribution-spec registry",
+	Long: `
+Each repository maps to an OCI layout directory under --root, and each tag
+to a ref name in that layout.  Pushing to a new repository creates the layout.
+
+$ ocidist serve --root /srv/oci --listen :5000
+$ ocidist images -T=false ocidist://localhost:5000/myrepo/myimage
+myrepo/myimage/v2.1
+`,
+	RunE:    doServe,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doServe(cmd *cobra.Command, args []string) error {
+	root, err := cmd.Flags().GetString("root")
+	if err != nil {
+		return err
+	}
+
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return err
+	}
+
+	tlsCert, err := cmd.Flags().GetString("tls-cert")
+	if err != nil {
+		return err
+	}
+
+	tlsKey, err := cmd.Flags().GetString("tls-key")
+	if err != nil {
+		return err
+	}
+
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be specified together")
+	}
+
+	srv, err := server.NewServer(root)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+
+	log.Infof("Serving OCI layouts from %q on %s", root, listen)
+	if tlsCert != "" {
+		return http.ListenAndServeTLS(listen, tlsCert, tlsKey, srv)
+	}
+	return http.ListenAndServe(listen, srv)
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	serveCmd.PersistentFlags().StringP("root", "r", ".", "directory containing OCI layouts to serve")
+	serveCmd.PersistentFlags().StringP("listen", "l", ":5000", "address to listen on")
+	serveCmd.PersistentFlags().String("tls-cert", "", "TLS certificate file, serve plain HTTP if unset")
+	serveCmd.PersistentFlags().String("tls-key", "", "TLS private key file")
+}
@@ -18,6 +18,7 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -129,12 +130,14 @@ func runSociInspect(cmd *cobra.Command, args []string) error {
 	_, verifyInfo, _ := sociRef.Verify(cmd.Flag("ca-file").Value.String())
 	info.Verification = verifyInfo
 
-	content, err := json.MarshalIndent(info, "", "  ")
-	if err != nil {
-		return err
-	}
-	fmt.Printf("%s\n", content)
-	return nil
+	return printOutput(cmd, info, func(w io.Writer) error {
+		content, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", content)
+		return nil
+	})
 }
 
 func runSociGet(cmd *cobra.Command, args []string) error {
@@ -164,13 +167,14 @@ func runSociGet(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	content, err := json.MarshalIndent(sociArtifacts, "", "  ")
-	if err != nil {
-		return err
-	}
-	fmt.Printf("%s\n", content)
-
-	return nil
+	return printOutput(cmd, sociArtifacts, func(w io.Writer) error {
+		content, err := json.MarshalIndent(sociArtifacts, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", content)
+		return nil
+	})
 }
 
 func runSociPut(cmd *cobra.Command, args []string) error {
@@ -317,6 +321,8 @@ func runSociBundle(cmd *cobra.Command, args []string) error {
 
 func init() {
 	sociInspectCmd.PersistentFlags().StringP("ca-file", "c", "", "verify soci cert is issued from specified CA and still valid")
+	addOutputFlags(sociInspectCmd)
+	addOutputFlags(sociGetCmd)
 
 	sociBundleCmd.PersistentFlags().StringP("install-file", "i", "", "specify path to artifact vnd.machine.install file")
 	sociBundleCmd.MarkPersistentFlagRequired("install-file")
@@ -0,0 +1,283 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/docker/go-units"
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/image"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync [<source URL> <dest URL>]",
+	Args:  cobra.RangeArgs(0, 2),
+	Short: "mirror every tag of a repository, or every repository of a registry",
+	Long: `
+Copy each source tag whose digest differs at the destination, along with its
+referrers.  Tags already synced still get referrers added since.  A registry
+URL without a repository path syncs every repository.
+
+$ ocidist sync ocidist://build:5000/myrepo/myimage ocidist://edge:5000/myrepo/myimage
+$ ocidist sync --include '^v2\.' --delete ocidist://build:5000 ocidist://edge:5000
+$ ocidist sync --state /var/lib/ocidist/edge.json ocidist://build:5000 oci:///mirror
+
+--include and --exclude match tags, --include-repo and --exclude-repo match
+repository names of a registry sync.  --state records synced digests so an
+interrupted sync resumes without checking the destination again.  --dry-run
+writes nothing, not even the state, and reports the blobs each tag is missing
+at the destination and the bytes that would be sent.
+
+Without URLs, every entry under 'sync' in the --config file is synced and a
+JSON report of each entry is printed, progress goes to stderr:
+
+$ ocidist sync --config mirror.yaml
+
+registries:
+  - host: build:5000
+    tls-verify: false
+  - host: edge:5000
+    username: mirror
+    password-env: EDGE_PASSWORD
+sync:
+  - name: app
+    source: ocidist://build:5000/myrepo/app
+    dest: ocidist://edge:5000/myrepo/app
+    tags:
+      semver: ">=1.2.0 <2.0.0"
+      exclude: ["-rc"]
+    platforms: [linux/amd64, linux/arm64]
+    referrers: true
+    delete: true
+    state: /var/lib/ocidist/app.json
+
+Selecting platforms writes a new index, a source index that has referrers to
+sync is refused as they would have no subject at the destination.
+
+Sources are checked against --policy, else the 'policy' of the source's
+registry entry, else the top-level 'policy' of the config file.
+`,
+	RunE:    doSync,
+	PreRunE: doBeforeRunCmd,
+}
+
+func compileFlagRegexps(cmd *cobra.Command, name string) ([]*regexp.Regexp, error) {
+	patterns, err := cmd.Flags().GetStringSlice(name)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --%s regex '%s': %s", name, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func doSync(cmd *cobra.Command, args []string) error {
+	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
+	if err != nil {
+		return err
+	}
+
+	del, err := cmd.Flags().GetBool("delete")
+	if err != nil {
+		return err
+	}
+
+	stateFile, err := cmd.Flags().GetString("state")
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	referrers, err := cmd.Flags().GetBool("referrers")
+	if err != nil {
+		return err
+	}
+
+	artifactTypes, err := cmd.Flags().GetStringSlice("artifact-type")
+	if err != nil {
+		return err
+	}
+
+	jobs, err := cmd.Flags().GetInt("jobs")
+	if err != nil {
+		return err
+	}
+
+	semverRange, err := cmd.Flags().GetString("semver")
+	if err != nil {
+		return err
+	}
+
+	platformList, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		return err
+	}
+
+	platforms, err := image.ParsePlatforms(platformList)
+	if err != nil {
+		return err
+	}
+
+	opts := api.SyncOpts{
+		SrcConfig:  &api.OCIAPIConfig{TLSVerify: tlsVerify},
+		DestConfig: &api.OCIAPIConfig{TLSVerify: tlsVerify},
+		Delete:     del,
+		StateFile:  stateFile,
+		DryRun:     dryRun,
+		Copy: api.NativeCopyOpts{
+			Referrers:     referrers,
+			ArtifactTypes: artifactTypes,
+			Platforms:     platforms,
+			Jobs:          jobs,
+			Progress:      os.Stdout,
+		},
+	}
+
+	if opts.PolicyPath, err = cmd.Flags().GetString("policy"); err != nil {
+		return err
+	}
+
+	switch len(args) {
+	case 0:
+		return doSyncConfig(cmd, opts)
+	case 1:
+		return fmt.Errorf("sync needs both a source and dest URL")
+	}
+
+	if opts.PolicyPath, err = policyPath(cmd, args[0]); err != nil {
+		return err
+	}
+
+	if semverRange != "" {
+		if opts.TagSemver, err = api.ParseSemverRange(semverRange); err != nil {
+			return err
+		}
+	}
+
+	if opts.IncludeTags, err = compileFlagRegexps(cmd, "include"); err != nil {
+		return err
+	}
+	if opts.ExcludeTags, err = compileFlagRegexps(cmd, "exclude"); err != nil {
+		return err
+	}
+	if opts.IncludeRepos, err = compileFlagRegexps(cmd, "include-repo"); err != nil {
+		return err
+	}
+	if opts.ExcludeRepos, err = compileFlagRegexps(cmd, "exclude-repo"); err != nil {
+		return err
+	}
+
+	if structuredOutput(cmd) {
+		opts.Copy.Progress = os.Stderr
+	}
+
+	report, err := api.Sync(args[0], args[1], opts)
+	if report == nil {
+		return err
+	}
+
+	printErr := printOutput(cmd, report.Results, func(w io.Writer) error {
+		if dryRun {
+			for _, result := range report.Results {
+				if result.Plan != nil {
+					result.Plan.Print(w)
+				}
+			}
+			fmt.Fprintf(w, "Would sync %d, skipped %d, would delete %d, failed %d, %s to send\n",
+				report.Count(api.SyncWouldCopy), report.Count(api.SyncSkipped),
+				report.Count(api.SyncWouldDelete), report.Count(api.SyncFailed),
+				units.BytesSize(float64(report.PlannedBytes())))
+		} else {
+			fmt.Fprintf(w, "Synced %d, skipped %d, deleted %d, failed %d\n",
+				report.Count(api.SyncCopied), report.Count(api.SyncSkipped),
+				report.Count(api.SyncDeleted), report.Count(api.SyncFailed))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return printErr
+}
+
+// doSyncConfig runs the sync entries of the config file, the command line
+// only supplies defaults.
+func doSyncConfig(cmd *cobra.Command, defaults api.SyncOpts) error {
+	if !viper.IsSet("sync") {
+		return fmt.Errorf("sync needs a source and dest URL, or a --config file with sync entries")
+	}
+
+	var config api.SyncConfig
+	if err := viper.Unmarshal(&config); err != nil {
+		return fmt.Errorf("Failed to parse sync config %q: %s", viper.ConfigFileUsed(), err)
+	}
+
+	defaults.Copy.Progress = os.Stderr
+	report, runErr := config.Run(defaults)
+
+	err := printOutput(cmd, report.Entries, func(w io.Writer) error {
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to marshal sync report: %s", err)
+		}
+		fmt.Fprintln(w, string(content))
+		return nil
+	})
+	if runErr != nil {
+		return runErr
+	}
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	syncCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	syncCmd.PersistentFlags().StringSlice("include", []string{}, "only sync tags matching one of these regexes")
+	syncCmd.PersistentFlags().StringSlice("exclude", []string{}, "do not sync tags matching any of these regexes")
+	syncCmd.PersistentFlags().StringSlice("include-repo", []string{}, "only sync repositories matching one of these regexes")
+	syncCmd.PersistentFlags().StringSlice("exclude-repo", []string{}, "do not sync repositories matching any of these regexes")
+	syncCmd.PersistentFlags().String("semver", "", "only sync tags that are versions within this range, e.g. '>=1.2.0 <2.0.0'")
+	syncCmd.PersistentFlags().String("platform", "", "sync only these comma separated os/arch[/variant] platforms of an index")
+	syncCmd.PersistentFlags().String("policy", "", "containers-policy.json every synced source must satisfy")
+	syncCmd.PersistentFlags().Bool("dry-run", false, "only report what would be copied and deleted, and the bytes to send")
+	syncCmd.PersistentFlags().Bool("delete", false, "delete destination tags that no longer exist at the source")
+	syncCmd.PersistentFlags().String("state", "", "state file recording synced digests, to resume or skip unchanged tags")
+	syncCmd.PersistentFlags().Bool("referrers", true, "also sync referrers of synced manifests")
+	syncCmd.PersistentFlags().StringSlice("artifact-type", []string{}, "only sync referrers with these artifact types")
+	syncCmd.PersistentFlags().IntP("jobs", "j", 0, "concurrent blob transfers per registry, 0 for the default")
+	addOutputFlags(syncCmd)
+}
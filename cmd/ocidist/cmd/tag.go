@@ -0,0 +1,84 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	"github.com/spf13/cobra"
+)
+
+// tagCmd represents the tag command
+var tagCmd = &cobra.Command{
+	Use:   "tag <URL> <tag|URL>...",
+	Args:  cobra.MinimumNArgs(2),
+	Short: "add tags to an image without copying it",
+	Long: `
+Put the exact manifest of URL under each new tag, keeping its digest.  A new
+tag is a tag in the same repository, or the URL of another repository in the
+same registry, which gets the image's layers mounted rather than uploaded:
+
+$ ocidist tag ocidist://localhost:5000/myrepo/myimage:v1.2.3 stable latest
+Tagged ocidist://localhost:5000/myrepo/myimage:stable sha256:...
+Tagged ocidist://localhost:5000/myrepo/myimage:latest sha256:...
+$ ocidist tag ocidist://localhost:5000/myrepo/myimage:v1.2.3 ocidist://localhost:5000/release/myimage:v1.2.3
+`,
+	RunE:    doTag,
+	PreRunE: doBeforeRunCmd,
+}
+
+func doTag(cmd *cobra.Command, args []string) error {
+	rawSrc := args[0]
+
+	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
+	if err != nil {
+		return err
+	}
+
+	config := &api.OCIAPIConfig{TLSVerify: tlsVerify}
+	srcApi, err := api.NewOCIAPI(rawSrc, config)
+	if err != nil {
+		return err
+	}
+
+	dests := []api.OCIAPI{}
+	for _, newTag := range args[1:] {
+		rawDest := newTag
+		if !strings.Contains(newTag, "://") {
+			if rawDest, err = api.RetagURL(rawSrc, newTag); err != nil {
+				return err
+			}
+		}
+
+		destApi, err := api.NewOCIAPI(rawDest, config)
+		if err != nil {
+			return err
+		}
+		dests = append(dests, destApi)
+	}
+
+	_, err = api.Tag(srcApi, dests, os.Stdout)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	tagCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	tagCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+}
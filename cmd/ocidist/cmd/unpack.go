@@ -0,0 +1,102 @@
+/*
+Copyright © 2023 Ryan Harper <rharper@woxford.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/raharper/ocidist/pkg/layer"
+
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/umoci/pkg/idtools"
+	"github.com/spf13/cobra"
+)
+
+// unpackCmd represents the unpack command
+var unpackCmd = &cobra.Command{
+	Use:   "unpack <URL> <dir>",
+	Args:  cobra.ExactArgs(2),
+	Short: "unpack the root filesystem of an image into a directory",
+	Long: `
+Apply every layer of the image in order to dir, which must be empty or not
+exist, handling whiteouts, opaque directories, hardlinks and xattrs.  Owners
+that cannot be set are ignored with --rootless, the default when not root,
+and --uid-map and --gid-map map the image's owners to the host's:
+
+$ ocidist unpack ocidist://localhost:5000/myrepo/myimage:v2.1 ./rootfs
+$ ocidist unpack --uid-map 0:100000:65536 --gid-map 0:100000:65536 oci:///ocidir:myimage:v2.1 ./rootfs
+`,
+	RunE:    doUnpack,
+	PreRunE: doBeforeRunCmd,
+}
+
+func parseIDMappings(cmd *cobra.Command, name string) ([]rspec.LinuxIDMapping, error) {
+	flags, err := cmd.Flags().GetStringSlice(name)
+	if err != nil {
+		return nil, err
+	}
+	// nil leaves owners unmapped
+	var mappings []rspec.LinuxIDMapping
+	for _, flag := range flags {
+		mapping, err := idtools.ParseMapping(flag)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --%s '%s', must be container:host:size: %s", name, flag, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+func doUnpack(cmd *cobra.Command, args []string) error {
+	opts := layer.UnpackOpts{Rootless: os.Geteuid() != 0}
+	if cmd.Flags().Changed("rootless") {
+		rootless, err := cmd.Flags().GetBool("rootless")
+		if err != nil {
+			return err
+		}
+		opts.Rootless = rootless
+	}
+
+	var err error
+	if opts.UIDMappings, err = parseIDMappings(cmd, "uid-map"); err != nil {
+		return err
+	}
+	if opts.GIDMappings, err = parseIDMappings(cmd, "gid-map"); err != nil {
+		return err
+	}
+
+	img, err := openImage(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := img.Unpack(args[1], opts); err != nil {
+		return fmt.Errorf("Failed to unpack '%s': %s", args[0], err)
+	}
+	fmt.Printf("Unpacked %s to %s\n", img.Digest, args[1])
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(unpackCmd)
+	unpackCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
+	unpackCmd.PersistentFlags().BoolP("tls-verify", "T", true, "toggle tls verification")
+	unpackCmd.PersistentFlags().String("platform", "", "os/arch[/variant] image of an index to unpack, the host's by default")
+	unpackCmd.PersistentFlags().Bool("rootless", false, "ignore failures to set owners, the default when not run as root")
+	unpackCmd.PersistentFlags().StringSlice("uid-map", []string{}, "container:host:size mappings of the image's uids")
+	unpackCmd.PersistentFlags().StringSlice("gid-map", []string{}, "container:host:size mappings of the image's gids")
+}
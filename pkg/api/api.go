@@ -1,23 +1,57 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"strings"
 
 	"github.com/raharper/ocidist/pkg/image"
 
 	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
 )
 
 type OCIRepoType string
 
 const (
-	OCIDirRepoType  OCIRepoType = "oci"
-	OCIDistRepoType OCIRepoType = "ocidist"
+	OCIDirRepoType        OCIRepoType = "oci"
+	OCIDistRepoType       OCIRepoType = "ocidist"
+	MemRepoType           OCIRepoType = "mem"
+	OCIArchiveRepoType    OCIRepoType = "oci-archive"
+	DockerArchiveRepoType OCIRepoType = "docker-archive"
 )
 
+var ErrNotFound = errors.New("not found")
+
+// DetectManifestMediaType returns the mediaType of a manifest or index,
+// falling back to the document shape when the mediaType field is absent.
+func DetectManifestMediaType(content []byte) (string, error) {
+	var doc struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+		Config    json.RawMessage   `json:"config"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return "", err
+	}
+	if doc.MediaType != "" {
+		return doc.MediaType, nil
+	}
+	if doc.Manifests != nil {
+		return ispec.MediaTypeImageIndex, nil
+	}
+	if doc.Config != nil {
+		return ispec.MediaTypeImageManifest, nil
+	}
+	return "", fmt.Errorf("document is not a manifest or index")
+}
+
 type OCIAPI interface {
 	Type() OCIRepoType
 
@@ -26,12 +60,18 @@ type OCIAPI interface {
 
 	GetRepoTagList() (*dspec.TagList, error)
 	GetManifest() (*ispec.Manifest, []byte, error)
+	GetManifestBytes(ref string) (string, []byte, error)
 	GetImage(*ispec.Descriptor) (*ispec.Image, error)
 	GetReferrers(*ispec.Descriptor) (*ispec.Index, error)
 	GetBlob(*ispec.Descriptor) ([]byte, error)
+	BlobHead(*ispec.Descriptor) error
 
 	PutBlob(*ispec.Descriptor, []byte) error
-	PutManifest(*ispec.Manifest) error
+	// PutManifest marshals a new manifest, PutManifestBytes stores existing
+	// content byte-for-byte, both return the digest the content is stored as
+	PutManifest(*ispec.Manifest) (digest.Digest, error)
+	PutManifestBytes(ref, mediaType string, content []byte) (digest.Digest, error)
+	DeleteManifest(ref string) error
 	PutArtifact(artifactName, artifactType string, artifactBlob []byte) error
 
 	ImageName() string
@@ -40,9 +80,108 @@ type OCIAPI interface {
 	RepoTag() string
 }
 
+// marshalManifest returns the content of a new manifest and the ref to put
+// it to, tag unless it has a subject, which is put by digest like referrers.
+func marshalManifest(manifest *ispec.Manifest, tag string) (string, string, []byte, error) {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", "", []byte{}, fmt.Errorf("Failed to marshal manifest: %s", err)
+	}
+
+	ref := tag
+	if manifest.Subject != nil {
+		ref = digest.FromBytes(content).String()
+	}
+
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = ispec.MediaTypeImageManifest
+	}
+	return ref, mediaType, content, nil
+}
+
+// putArtifact puts artifactBlob, titled artifactName, as the only layer of an
+// artifactType manifest.  The manifest at ociApi's tag, if any, becomes its
+// subject so the artifact is found through the referrers of that manifest.
+func putArtifact(ociApi OCIAPI, artifactName, artifactType string, artifactBlob []byte) error {
+	log.WithFields(log.Fields{
+		"url":          ociApi.SourceURL(),
+		"artifactName": artifactName,
+		"artifactType": artifactType,
+	}).Debug("putArtifact() called")
+
+	emptyConfig := ispec.Descriptor{
+		MediaType: "application/vnd.oci.empty.v1+json",
+		Size:      2,
+		Digest:    digest.FromBytes([]byte("{}")),
+	}
+	blobs := []ispec.Descriptor{
+		{
+			MediaType: "application/octet-stream",
+			Size:      int64(len(artifactBlob)),
+			Digest:    digest.FromBytes(artifactBlob),
+			Annotations: map[string]string{
+				ispec.AnnotationTitle: artifactName,
+			},
+		},
+	}
+
+	if err := ociApi.PutBlob(&emptyConfig, []byte("{}")); err != nil {
+		return fmt.Errorf("Failed to put empty config blob: %s", err)
+	}
+	if err := ociApi.PutBlob(&blobs[0], artifactBlob); err != nil {
+		return fmt.Errorf("Failed to put artifact blob: %s", err)
+	}
+
+	manifest := ispec.Manifest{
+		MediaType:    ispec.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       emptyConfig,
+		Layers:       blobs,
+		Versioned:    ManifestV2,
+	}
+
+	// reference an existing manifest at this tag as the subject
+	if refMediaType, refMBytes, err := ociApi.GetManifestBytes(ociApi.RepoTag()); err == nil {
+		if refMediaType == "" {
+			refMediaType, _ = DetectManifestMediaType(refMBytes)
+		}
+		manifest.Subject = &ispec.Descriptor{
+			MediaType: refMediaType,
+			Digest:    digest.FromBytes(refMBytes),
+			Size:      int64(len(refMBytes)),
+		}
+	} else {
+		log.Debugf("putArtifact() no manifest at tag %q, skipping subject: %s", ociApi.RepoTag(), err)
+	}
+
+	ref, mediaType, content, err := marshalManifest(&manifest, ociApi.RepoTag())
+	if err != nil {
+		return err
+	}
+	if _, err := ociApi.PutManifestBytes(ref, mediaType, content); err != nil {
+		return fmt.Errorf("Failed to put artifact manifest: %s", err)
+	}
+	return nil
+}
+
+// BlobMounter is an OCIAPI that can link a blob from another repository of
+// the same registry instead of uploading it
+type BlobMounter interface {
+	MountBlob(layer *ispec.Descriptor, fromRepo string) (bool, error)
+}
+
+// BlobWriter is an OCIAPI that can upload a blob as it is read from reader
+type BlobWriter interface {
+	PutBlobReader(layer *ispec.Descriptor, reader io.Reader) error
+}
+
 type OCIAPIConfig struct {
 	TLSVerify bool
 	Debug     bool
+	// registry credentials, anonymous if empty
+	Username string
+	Password string
 }
 
 func NewOCIAPI(rawURL string, config *OCIAPIConfig) (OCIAPI, error) {
@@ -57,6 +196,12 @@ func NewOCIAPI(rawURL string, config *OCIAPIConfig) (OCIAPI, error) {
 		return NewOCIDistRepo(url, config)
 	case "oci":
 		return NewOCIDirRepo(url, config)
+	case "oci-archive":
+		return NewOCIArchiveRepo(url, config)
+	case "docker-archive":
+		return NewDockerArchiveRepo(url, config)
+	case "mem":
+		return NewMemRepo(url, config)
 	}
 
 	return nil, fmt.Errorf("Unknown URL scheme '%s' in url '%s'", url.Scheme, rawURL)
@@ -72,9 +217,9 @@ func ImageCopy(src, dest string, opts image.ImageCopyOpts) error {
 
 		opts.Src = srcURL.String()
 		switch srcURL.Scheme {
-		case "ocidist", "docker", "oci":
+		case "ocidist", "docker", "oci", "oci-archive", "docker-archive":
 		default:
-			return fmt.Errorf("source url has unsupported scheme '%s', must be 'docker', 'ocidist', or 'oci'", srcURL.Scheme)
+			return fmt.Errorf("source url has unsupported scheme '%s', must be 'docker', 'ocidist', 'oci', 'oci-archive' or 'docker-archive'", srcURL.Scheme)
 		}
 	}
 
@@ -86,11 +231,9 @@ func ImageCopy(src, dest string, opts image.ImageCopyOpts) error {
 
 		opts.Dest = destURL.String()
 		switch destURL.Scheme {
-		case "ocidist":
-			opts.Dest = fmt.Sprintf("docker://%s", destURL.Path)
-		case "docker", "oci":
+		case "ocidist", "docker", "oci", "oci-archive":
 		default:
-			return fmt.Errorf("destination url has unsupported scheme '%s', must be 'docker' or 'oci'", destURL.Scheme)
+			return fmt.Errorf("destination url has unsupported scheme '%s', must be 'docker', 'ocidist', 'oci' or 'oci-archive'", destURL.Scheme)
 		}
 	}
 
@@ -104,3 +247,30 @@ func ImageCopy(src, dest string, opts image.ImageCopyOpts) error {
 
 	return nil
 }
+
+// CheckPolicy refuses src unless the signature policy in opts accepts the
+// manifest at dgst, which src's tag resolved to.  NativeCopy does not verify
+// signatures, so it is checked before copying and the copy is pinned to dgst.
+func CheckPolicy(src string, dgst digest.Digest, opts image.ImageCopyOpts) error {
+	if opts.PolicyPath == "" {
+		return nil
+	}
+
+	srcURL, err := url.Parse(src)
+	if err != nil {
+		return fmt.Errorf("Failed to parse source url '%s': %s", src, err)
+	}
+
+	switch srcURL.Scheme {
+	case "ocidist", "docker":
+		// check the manifest by digest, a tag could move in the meantime
+		repo, _, _ := strings.Cut(srcURL.Path, ":")
+		srcURL.Path = fmt.Sprintf("%s@%s", repo, dgst)
+	case "oci", "oci-archive", "docker-archive":
+	default:
+		return fmt.Errorf("signature policy cannot be checked for source url scheme '%s'", srcURL.Scheme)
+	}
+
+	opts.Src = srcURL.String()
+	return image.CheckPolicy(opts, dgst)
+}
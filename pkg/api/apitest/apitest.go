@@ -0,0 +1,111 @@
+// Package apitest builds images in an OCIAPI for the tests of the packages
+// built on it.
+package apitest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// NewMemRepo returns a mem:// repository for repoTag in a store of the test's
+// own, dropped when the test ends
+func NewMemRepo(t testing.TB, repoTag string) api.OCIAPI {
+	t.Helper()
+	store := t.Name()
+	t.Cleanup(func() { api.DeleteMemStore(store) })
+
+	ociApi, err := api.NewOCIAPI("mem://"+store+"/"+repoTag, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mem repo: %s", err)
+	}
+	return ociApi
+}
+
+// PutBlob puts blob in ociApi and returns its descriptor
+func PutBlob(t testing.TB, ociApi api.OCIAPI, mediaType string, blob []byte) ispec.Descriptor {
+	t.Helper()
+	desc := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	if err := ociApi.PutBlob(&desc, blob); err != nil {
+		t.Fatalf("Failed to put blob: %s", err)
+	}
+	return desc
+}
+
+// PutManifest marshals v, a manifest or index, and puts it in ociApi at ref
+func PutManifest(t testing.TB, ociApi api.OCIAPI, ref, mediaType string, v interface{}) ispec.Descriptor {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %s", err)
+	}
+	dgst, err := ociApi.PutManifestBytes(ref, mediaType, content)
+	if err != nil {
+		t.Fatalf("Failed to put manifest at %s: %s", ref, err)
+	}
+	return ispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+}
+
+// Manifest puts a linux/amd64 config and layers, a single "layer" blob if
+// none are given, in ociApi and returns an image manifest of them
+func Manifest(t testing.TB, ociApi api.OCIAPI, layers ...[]byte) ispec.Manifest {
+	t.Helper()
+	if len(layers) == 0 {
+		layers = [][]byte{[]byte("layer")}
+	}
+
+	config, err := json.Marshal(ispec.Image{
+		Platform: ispec.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   ispec.RootFS{Type: "layers"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %s", err)
+	}
+
+	manifest := ispec.Manifest{
+		Versioned: api.ManifestV2,
+		MediaType: ispec.MediaTypeImageManifest,
+		Config:    PutBlob(t, ociApi, ispec.MediaTypeImageConfig, config),
+		Layers:    []ispec.Descriptor{},
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, PutBlob(t, ociApi, ispec.MediaTypeImageLayer, layer))
+	}
+	return manifest
+}
+
+// PushImage puts a Manifest of layers at ociApi's tag
+func PushImage(t testing.TB, ociApi api.OCIAPI, layers ...[]byte) ispec.Descriptor {
+	t.Helper()
+	return PutManifest(t, ociApi, ociApi.RepoTag(), ispec.MediaTypeImageManifest, Manifest(t, ociApi, layers...))
+}
+
+// PushIndex puts an image for each platform, by digest, and an index of them
+// at ociApi's tag
+func PushIndex(t testing.TB, ociApi api.OCIAPI, platforms ...ispec.Platform) ispec.Descriptor {
+	t.Helper()
+	index := ispec.Index{
+		Versioned: api.ManifestV2,
+		MediaType: ispec.MediaTypeImageIndex,
+		Manifests: []ispec.Descriptor{},
+	}
+	for _, platform := range platforms {
+		manifest := Manifest(t, ociApi, []byte("layer for "+platform.OS+"/"+platform.Architecture))
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatalf("Failed to marshal manifest: %s", err)
+		}
+		desc := PutManifest(t, ociApi, digest.FromBytes(content).String(), ispec.MediaTypeImageManifest, manifest)
+		desc.Platform = &ispec.Platform{OS: platform.OS, Architecture: platform.Architecture}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	return PutManifest(t, ociApi, ociApi.RepoTag(), ispec.MediaTypeImageIndex, index)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"io"
+
+	"github.com/containers/image/v5/pkg/compression"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// layerCompressionMediaTypes maps each NativeCopyOpts.Compression to the
+// media type of its layers
+var layerCompressionMediaTypes = map[string]string{
+	"none": ispec.MediaTypeImageLayer,
+	"gzip": ispec.MediaTypeImageLayerGzip,
+	"zstd": ispec.MediaTypeImageLayerZstd,
+}
+
+// dockerOCIMediaTypes maps docker schema2 media types to the OCI media types
+// a converted manifest uses instead.  Foreign layers have no OCI equivalent
+// that may still be written.
+var dockerOCIMediaTypes = map[string]string{
+	MediaTypeDockerManifest:     ispec.MediaTypeImageManifest,
+	MediaTypeDockerManifestList: ispec.MediaTypeImageIndex,
+	MediaTypeDockerConfig:       ispec.MediaTypeImageConfig,
+	MediaTypeDockerLayer:        ispec.MediaTypeImageLayerGzip,
+}
+
+// ociMediaType returns the OCI equivalent of a docker media type, or
+// mediaType itself
+func ociMediaType(mediaType string) string {
+	if ociType, ok := dockerOCIMediaTypes[mediaType]; ok {
+		return ociType
+	}
+	return mediaType
+}
+
+func isImageLayerMediaType(mediaType string) bool {
+	mediaType = ociMediaType(mediaType)
+	for _, layerMediaType := range layerCompressionMediaTypes {
+		if mediaType == layerMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// recompress decompresses a layer read from reader, whatever its
+// compression, and returns a reader of it compressed again as "gzip", "zstd"
+// or "none".  The layer is compressed as it is read rather than held in
+// memory.
+func recompress(reader io.Reader, algorithm string, level *int) (io.ReadCloser, error) {
+	_, decompressor, reader, err := compression.DetectCompressionFormat(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed := io.NopCloser(reader)
+	if decompressor != nil {
+		decompressed, err = decompressor(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if algorithm == "none" {
+		return decompressed, nil
+	}
+
+	algo, err := compression.AlgorithmByName(algorithm)
+	if err != nil {
+		decompressed.Close()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	compressor, err := compression.CompressStream(pw, algo, level)
+	if err != nil {
+		decompressed.Close()
+		return nil, err
+	}
+	go func() {
+		defer decompressed.Close()
+		_, err := io.Copy(compressor, decompressed)
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
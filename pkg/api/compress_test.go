@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/compression"
+)
+
+func TestRecompress(t *testing.T) {
+	layer := bytes.Repeat([]byte("layer content "), 1000)
+
+	for _, algorithm := range []string{"gzip", "zstd", "none"} {
+		t.Run(algorithm, func(t *testing.T) {
+			converted, err := recompress(bytes.NewReader(layer), algorithm, nil)
+			if err != nil {
+				t.Fatalf("Failed to recompress: %s", err)
+			}
+			blob, err := io.ReadAll(converted)
+			converted.Close()
+			if err != nil {
+				t.Fatalf("Failed to read recompressed layer: %s", err)
+			}
+
+			format, decompressor, reader, err := compression.DetectCompressionFormat(bytes.NewReader(blob))
+			if err != nil {
+				t.Fatalf("Failed to detect compression: %s", err)
+			}
+			name := "none"
+			if decompressor != nil {
+				name = format.Name()
+				decompressed, err := decompressor(reader)
+				if err != nil {
+					t.Fatalf("Failed to decompress: %s", err)
+				}
+				defer decompressed.Close()
+				reader = decompressed
+			}
+			if name != algorithm {
+				t.Errorf("Layer compressed as %s, expected %s", name, algorithm)
+			}
+
+			// recompressing a compressed layer decompresses it first
+			again, err := recompress(bytes.NewReader(blob), "none", nil)
+			if err != nil {
+				t.Fatalf("Failed to recompress again: %s", err)
+			}
+			defer again.Close()
+			for _, r := range []io.Reader{reader, again} {
+				content, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("Failed to read layer: %s", err)
+				}
+				if !bytes.Equal(content, layer) {
+					t.Errorf("Layer content changed by recompressing")
+				}
+			}
+		})
+	}
+}
+
+func TestIsImageLayerMediaType(t *testing.T) {
+	for mediaType, want := range map[string]bool{
+		"application/vnd.oci.image.layer.v1.tar":      true,
+		"application/vnd.oci.image.layer.v1.tar+gzip": true,
+		"application/vnd.oci.image.layer.v1.tar+zstd": true,
+		MediaTypeDockerLayer:                          true,
+		MediaTypeDockerForeignLayer:                   false,
+		"application/vnd.oci.image.config.v1+json":    false,
+		"application/vnd.example.sig":                 false,
+	} {
+		if got := isImageLayerMediaType(mediaType); got != want {
+			t.Errorf("isImageLayerMediaType(%s) = %v, expected %v", mediaType, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Config holds the settings of the config file shared by every command
+type Config struct {
+	// containers-policy.json for sources whose registry sets no policy
+	Policy     string           `mapstructure:"policy"`
+	Registries []RegistryConfig `mapstructure:"registries"`
+}
+
+// RegistryConfig holds the settings for every URL with this host
+type RegistryConfig struct {
+	Host      string `mapstructure:"host"`
+	TLSVerify *bool  `mapstructure:"tls-verify"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
+	// read the password from this environment variable instead
+	PasswordEnv string `mapstructure:"password-env"`
+	// containers-policy.json enforced when copying from this registry
+	Policy string `mapstructure:"policy"`
+}
+
+// Registry returns the settings for rawURL's host, or nil if there are none
+func (c *Config) Registry(rawURL string) *RegistryConfig {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+
+	for i := range c.Registries {
+		if c.Registries[i].Host == u.Host {
+			return &c.Registries[i]
+		}
+	}
+	return nil
+}
+
+// APIConfig returns defaults overlaid with the settings of rawURL's registry
+func (c *Config) APIConfig(rawURL string, defaults *OCIAPIConfig) (*OCIAPIConfig, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, fmt.Errorf("Failed to parse url '%s': %s", rawURL, err)
+	}
+
+	config := *defaults
+	registry := c.Registry(rawURL)
+	if registry == nil {
+		return &config, nil
+	}
+
+	if registry.TLSVerify != nil {
+		config.TLSVerify = *registry.TLSVerify
+	}
+	config.Username = registry.Username
+	config.Password = registry.Password
+	if registry.PasswordEnv != "" {
+		config.Password = os.Getenv(registry.PasswordEnv)
+	}
+	return &config, nil
+}
+
+// PolicyPath returns the signature policy for copies from rawURL
+func (c *Config) PolicyPath(rawURL string) string {
+	if registry := c.Registry(rawURL); registry != nil && registry.Policy != "" {
+		return registry.Policy
+	}
+	return c.Policy
+}
@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+)
+
+func TestConfigAPIConfig(t *testing.T) {
+	verify := false
+	t.Setenv("OCIDIST_TEST_PASSWORD", "from-env")
+	config := api.Config{
+		Policy: "/etc/ocidist/policy.json",
+		Registries: []api.RegistryConfig{
+			{Host: "build:5000", TLSVerify: &verify, Username: "builder", Password: "secret", Policy: "/etc/ocidist/build.json"},
+			{Host: "prod:5000", Username: "deployer", PasswordEnv: "OCIDIST_TEST_PASSWORD"},
+		},
+	}
+	defaults := &api.OCIAPIConfig{TLSVerify: true, Debug: true, Username: "default", Password: "default"}
+
+	for _, tc := range []struct {
+		url      string
+		expected api.OCIAPIConfig
+		policy   string
+	}{
+		{
+			url:      "ocidist://build:5000/repo/img:v1",
+			expected: api.OCIAPIConfig{TLSVerify: false, Debug: true, Username: "builder", Password: "secret"},
+			policy:   "/etc/ocidist/build.json",
+		},
+		// tls-verify unset keeps the default, password-env is read
+		{
+			url:      "ocidist://prod:5000/repo/img:v1",
+			expected: api.OCIAPIConfig{TLSVerify: true, Debug: true, Username: "deployer", Password: "from-env"},
+			policy:   "/etc/ocidist/policy.json",
+		},
+		{
+			url:      "ocidist://other:5000/repo/img:v1",
+			expected: *defaults,
+			policy:   "/etc/ocidist/policy.json",
+		},
+		// local URLs have no registry
+		{
+			url:      "oci:///images/oci:img:v1",
+			expected: *defaults,
+			policy:   "/etc/ocidist/policy.json",
+		},
+	} {
+		apiConfig, err := config.APIConfig(tc.url, defaults)
+		if err != nil {
+			t.Fatalf("APIConfig(%s) failed: %s", tc.url, err)
+		}
+		if *apiConfig != tc.expected {
+			t.Errorf("APIConfig(%s) returned %+v, expected %+v", tc.url, *apiConfig, tc.expected)
+		}
+		if policy := config.PolicyPath(tc.url); policy != tc.policy {
+			t.Errorf("PolicyPath(%s) returned %q, expected %q", tc.url, policy, tc.policy)
+		}
+	}
+
+	// the defaults are copied, not changed
+	if !defaults.TLSVerify || defaults.Username != "default" {
+		t.Errorf("APIConfig changed the defaults to %+v", *defaults)
+	}
+	if _, err := config.APIConfig("ocidist://%zz", defaults); err == nil {
+		t.Errorf("APIConfig of an invalid URL succeeded")
+	}
+}
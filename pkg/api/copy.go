@@ -0,0 +1,738 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/raharper/ocidist/pkg/image"
+	"github.com/raharper/ocidist/pkg/progress"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCopyJobs is the number of concurrent blob transfers per registry
+const DefaultCopyJobs = 4
+
+// blobProgressInterval is how often a blob upload reports its progress, as
+// often as the containers/image copy does
+const blobProgressInterval = 500 * time.Millisecond
+
+type NativeCopyOpts struct {
+	// copy every manifest whose subject is a copied manifest, recursively
+	Referrers bool
+	// the manifest is already at the destination, only copy the referrers
+	// of it and of an index's manifests
+	ReferrersOnly bool
+	// only copy referrers with one of these artifactTypes, all if empty
+	ArtifactTypes []string
+	// copy a whole index, or an index of only these platforms, instead of
+	// just the image for the host platform
+	All       bool
+	Platforms []ispec.Platform
+	// concurrent blob transfers per registry, DefaultCopyJobs if unset
+	Jobs int
+	// recompress image layers as "gzip", "zstd" or "none", which writes new
+	// manifests, and indexes of them, with new digests
+	Compression      string
+	CompressionLevel *int
+	// fail rather than write a manifest with a different digest
+	PreserveDigests bool
+	// copy the source manifest at this digest, as checked against a policy,
+	// instead of whatever the source tag points at
+	SourceDigest digest.Digest
+	Progress     io.Writer
+	// receives an event for each blob and manifest, and when done
+	Reporter progress.Reporter
+}
+
+// nativeCopy carries the state of one NativeCopy call
+type nativeCopy struct {
+	src  OCIAPI
+	dest OCIAPI
+	opts NativeCopyOpts
+
+	// what each source manifest or blob was written as
+	lock   sync.Mutex
+	copied map[digest.Digest]ispec.Descriptor
+
+	// one transfer per blob digest at a time
+	inflight singleflight.Group
+	// one semaphore per registry, shared if src and dest are the same
+	srcSem  chan struct{}
+	destSem chan struct{}
+
+	// record what would be written here instead of writing it
+	plan *CopyPlan
+}
+
+// syncWriter serializes progress written by concurrent transfers
+type syncWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	return sw.writer.Write(p)
+}
+
+// registryKey identifies the registry, or local storage, behind an OCIAPI
+func registryKey(api OCIAPI) string {
+	u, err := url.Parse(api.SourceURL())
+	if err != nil {
+		return api.SourceURL()
+	}
+	return fmt.Sprintf("%s/%s", api.Type(), u.Host)
+}
+
+// NativeCopy copies the manifest at the source URL's tag to the destination
+// URL's tag using only OCIAPI, so manifests are copied byte-for-byte and keep
+// their digests.  Child manifests, config and layers are copied by digest
+// before anything that references them.  An index filtered by platform is
+// the one thing written with a new digest.
+func NativeCopy(src, dest OCIAPI, opts NativeCopyOpts) (err error) {
+	defer func() {
+		if err != nil {
+			progress.Send(opts.Reporter, progress.Event{Type: progress.Error, Source: src.SourceURL(), Dest: dest.SourceURL(), Error: err.Error()})
+		}
+	}()
+
+	nc, err := newNativeCopy(src, dest, opts)
+	if err != nil {
+		return err
+	}
+
+	written, err := nc.run()
+	if err != nil {
+		return err
+	}
+
+	if opts.ReferrersOnly {
+		fmt.Fprintf(nc.opts.Progress, "Copied referrers of %s to %s\n", written.Digest, dest.SourceURL())
+	} else {
+		fmt.Fprintf(nc.opts.Progress, "Copied %s to %s\n", written.Digest, dest.SourceURL())
+	}
+	progress.Send(opts.Reporter, progress.Event{Type: progress.Done, Digest: written.Digest, MediaType: written.MediaType, Size: written.Size, Source: src.SourceURL(), Dest: dest.SourceURL()})
+	return nil
+}
+
+func newNativeCopy(src, dest OCIAPI, opts NativeCopyOpts) (*nativeCopy, error) {
+	if opts.Progress == nil {
+		opts.Progress = io.Discard
+	}
+	opts.Progress = &syncWriter{writer: opts.Progress}
+
+	if opts.Jobs < 1 {
+		opts.Jobs = DefaultCopyJobs
+	}
+
+	if opts.ReferrersOnly && !opts.Referrers {
+		return nil, fmt.Errorf("Copying only referrers needs Referrers")
+	}
+
+	if opts.Compression != "" {
+		if _, ok := layerCompressionMediaTypes[opts.Compression]; !ok {
+			return nil, fmt.Errorf("Unsupported compression '%s', must be gzip, zstd or none", opts.Compression)
+		}
+		if opts.Referrers {
+			return nil, fmt.Errorf("Referrers cannot be copied to recompressed manifests, their subjects would not match")
+		}
+	}
+
+	nc := &nativeCopy{
+		src:    src,
+		dest:   dest,
+		opts:   opts,
+		copied: map[digest.Digest]ispec.Descriptor{},
+		srcSem: make(chan struct{}, opts.Jobs),
+	}
+	nc.destSem = nc.srcSem
+	if registryKey(src) != registryKey(dest) {
+		nc.destSem = make(chan struct{}, opts.Jobs)
+	}
+	return nc, nil
+}
+
+// run copies the source tag to the destination tag
+func (nc *nativeCopy) run() (ispec.Descriptor, error) {
+	ref := nc.src.RepoTag()
+	if nc.opts.SourceDigest != "" {
+		ref = nc.opts.SourceDigest.String()
+	}
+	mediaType, content, err := nc.src.GetManifestBytes(ref)
+	if err != nil {
+		return ispec.Descriptor{}, fmt.Errorf("Failed to get source manifest: %s", err)
+	}
+
+	source := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+	filtered := false
+	if isIndexMediaType(mediaType) && !nc.opts.All {
+		if nc.opts.PreserveDigests && len(nc.opts.Platforms) > 0 {
+			return ispec.Descriptor{}, fmt.Errorf("Selecting platforms writes a new index, refusing to change digests")
+		}
+		mediaType, content, err = nc.selectPlatforms(mediaType, content)
+		if err != nil {
+			return ispec.Descriptor{}, err
+		}
+		filtered = isIndexMediaType(mediaType)
+	}
+
+	// referrers of the source index name it as their subject, which the
+	// filtered index replaces at the destination
+	if filtered && nc.opts.Referrers {
+		if err := nc.checkIndexReferrers(source); err != nil {
+			return ispec.Descriptor{}, err
+		}
+	}
+
+	desc := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+
+	log.WithFields(log.Fields{
+		"src":    nc.src.SourceURL(),
+		"dest":   nc.dest.SourceURL(),
+		"digest": desc.Digest,
+		"plan":   nc.plan != nil,
+	}).Debug("NativeCopy() copying manifest")
+
+	if nc.opts.ReferrersOnly {
+		return desc, nc.copyExistingReferrers(desc, content)
+	}
+	return nc.copyManifest(desc, content, nc.dest.RepoTag(), progress.ManifestPushed)
+}
+
+// copyExistingReferrers copies the referrers of a manifest the destination
+// already has, and of the manifests of an index, which are at the same
+// digests as only recompressing rewrites them.
+func (nc *nativeCopy) copyExistingReferrers(desc ispec.Descriptor, content []byte) error {
+	if isIndexMediaType(desc.MediaType) {
+		var index ispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return fmt.Errorf("Failed to parse index '%s': %s", desc.Digest, err)
+		}
+		for _, child := range index.Manifests {
+			if _, ok := nc.copiedAs(child.Digest); ok {
+				continue
+			}
+			mediaType, childContent, err := nc.src.GetManifestBytes(child.Digest.String())
+			if err != nil {
+				return fmt.Errorf("Failed to get manifest '%s': %s", child.Digest, err)
+			}
+			child.MediaType = mediaType
+			if err := nc.copyExistingReferrers(child, childContent); err != nil {
+				return err
+			}
+		}
+	}
+
+	nc.markCopied(desc.Digest, desc)
+	return nc.copyReferrers(desc)
+}
+
+// checkIndexReferrers refuses to select platforms of an index that has
+// referrers to copy, they would have no subject at the destination
+func (nc *nativeCopy) checkIndexReferrers(index ispec.Descriptor) error {
+	refs, err := nc.src.GetReferrers(&index)
+	if err != nil {
+		return fmt.Errorf("Failed to get referrers of '%s': %s", index.Digest, err)
+	}
+
+	count := 0
+	for _, referrer := range refs.Manifests {
+		if nc.artifactTypeAllowed(referrer.ArtifactType) {
+			count++
+		}
+	}
+	if count > 0 {
+		return fmt.Errorf("Index '%s' has %d referrers that selecting platforms would leave without a subject, copy all platforms or no referrers", index.Digest, count)
+	}
+	return nil
+}
+
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == ispec.MediaTypeImageIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// selectPlatforms returns the host platform's manifest from an index, or if
+// platforms were requested an index of only those platforms.
+func (nc *nativeCopy) selectPlatforms(mediaType string, content []byte) (string, []byte, error) {
+	var index ispec.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return "", []byte{}, fmt.Errorf("Failed to parse index: %s", err)
+	}
+
+	if len(nc.opts.Platforms) == 0 {
+		host := ispec.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+		for _, child := range index.Manifests {
+			if image.PlatformMatches(host, child.Platform) {
+				fmt.Fprintf(nc.opts.Progress, "Selected %s/%s image %s\n", host.OS, host.Architecture, child.Digest)
+				return nc.src.GetManifestBytes(child.Digest.String())
+			}
+		}
+		return "", []byte{}, fmt.Errorf("No image for platform %s/%s in index, use --all or --platform", host.OS, host.Architecture)
+	}
+
+	count := len(index.Manifests)
+	filtered, err := filterIndexPlatforms(&index, nc.opts.Platforms)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	fmt.Fprintf(nc.opts.Progress, "Selected %d of %d images from index\n", len(index.Manifests), count)
+	return mediaType, filtered, nil
+}
+
+// filterIndexPlatforms drops every manifest not matching one of platforms
+// from index and returns the filtered index content.
+func filterIndexPlatforms(index *ispec.Index, platforms []ispec.Platform) ([]byte, error) {
+	selected := []ispec.Descriptor{}
+	for _, platform := range platforms {
+		found := false
+		for _, child := range index.Manifests {
+			if image.PlatformMatches(platform, child.Platform) {
+				selected = append(selected, child)
+				found = true
+			}
+		}
+		if !found {
+			return []byte{}, fmt.Errorf("No image for platform %s/%s in index", platform.OS, platform.Architecture)
+		}
+	}
+
+	index.Manifests = selected
+	filtered, err := json.Marshal(index)
+	if err != nil {
+		return []byte{}, fmt.Errorf("Failed to marshal index: %s", err)
+	}
+	return filtered, nil
+}
+
+func (nc *nativeCopy) artifactTypeAllowed(artifactType string) bool {
+	if len(nc.opts.ArtifactTypes) == 0 {
+		return true
+	}
+	for _, allowed := range nc.opts.ArtifactTypes {
+		if allowed == artifactType {
+			return true
+		}
+	}
+	return false
+}
+
+// copyManifest copies everything content references and then content
+// itself, to ref if set or else by digest, and returns what was written.
+// Referrers of desc follow.  pushed is the event reported once written.
+func (nc *nativeCopy) copyManifest(desc ispec.Descriptor, content []byte, ref, pushed string) (ispec.Descriptor, error) {
+	var doc struct {
+		Config    *ispec.Descriptor  `json:"config"`
+		Layers    []ispec.Descriptor `json:"layers"`
+		Manifests []ispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return ispec.Descriptor{}, fmt.Errorf("Failed to parse manifest '%s': %s", desc.Digest, err)
+	}
+
+	edits := map[string]interface{}{}
+	for i, child := range doc.Manifests {
+		written, ok := nc.copiedAs(child.Digest)
+		if !ok {
+			_, childContent, err := nc.src.GetManifestBytes(child.Digest.String())
+			if err != nil {
+				return ispec.Descriptor{}, fmt.Errorf("Failed to get manifest '%s': %s", child.Digest, err)
+			}
+			if written, err = nc.copyManifest(child, childContent, "", progress.ManifestPushed); err != nil {
+				return ispec.Descriptor{}, err
+			}
+		}
+		if written.Digest != child.Digest || written.MediaType != child.MediaType {
+			doc.Manifests[i].MediaType = written.MediaType
+			doc.Manifests[i].Digest = written.Digest
+			doc.Manifests[i].Size = written.Size
+			edits["manifests"] = doc.Manifests
+		}
+	}
+
+	// recompressed layers are written as OCI layers, so docker manifests and
+	// their configs become OCI ones
+	convert := nc.opts.Compression != "" && doc.Config != nil
+	if nc.opts.Compression != "" && ociMediaType(desc.MediaType) != desc.MediaType {
+		edits["mediaType"] = ociMediaType(desc.MediaType)
+	}
+	if convert {
+		if ociMediaType(desc.MediaType) != ispec.MediaTypeImageManifest {
+			return ispec.Descriptor{}, fmt.Errorf("Cannot recompress layers of %s manifest '%s'", desc.MediaType, desc.Digest)
+		}
+		if ociMediaType(doc.Config.MediaType) != doc.Config.MediaType {
+			doc.Config.MediaType = ociMediaType(doc.Config.MediaType)
+			edits["config"] = doc.Config
+		}
+	}
+
+	// the semaphores bound each registry, this just bounds goroutines
+	group := errgroup.Group{}
+	group.SetLimit(2 * nc.opts.Jobs)
+	if doc.Config != nil {
+		config := *doc.Config
+		group.Go(func() error {
+			return nc.copyBlob(config)
+		})
+	}
+	layers := make([]ispec.Descriptor, len(doc.Layers))
+	for i, layer := range doc.Layers {
+		i, layer := i, layer
+		group.Go(func() error {
+			var err error
+			layers[i] = layer
+			if convert {
+				if layer.MediaType == MediaTypeDockerForeignLayer {
+					return fmt.Errorf("Cannot convert foreign layer '%s'", layer.Digest)
+				}
+				layers[i].MediaType = ociMediaType(layer.MediaType)
+				if layers[i].MediaType != layerCompressionMediaTypes[nc.opts.Compression] && isImageLayerMediaType(layer.MediaType) {
+					layers[i], err = nc.convertLayer(layer)
+					return err
+				}
+			}
+			return nc.copyBlob(layer)
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return ispec.Descriptor{}, err
+	}
+	for i := range layers {
+		if layers[i].Digest != doc.Layers[i].Digest || layers[i].MediaType != doc.Layers[i].MediaType {
+			edits["layers"] = layers
+		}
+	}
+
+	written := desc
+	if mediaType, ok := edits["mediaType"]; ok {
+		written.MediaType = mediaType.(string)
+	}
+	if len(edits) > 0 {
+		edited, err := editManifest(content, edits)
+		if err != nil {
+			return ispec.Descriptor{}, fmt.Errorf("Failed to rewrite manifest '%s': %s", desc.Digest, err)
+		}
+		content = edited
+		written.Digest = digest.FromBytes(content)
+		written.Size = int64(len(content))
+		if nc.opts.PreserveDigests {
+			return ispec.Descriptor{}, fmt.Errorf("Copying manifest '%s' would change its digest to '%s'", desc.Digest, written.Digest)
+		}
+	}
+
+	if nc.plan != nil {
+		nc.planManifest(ref, desc, len(edits) > 0)
+	} else {
+		if ref == "" {
+			ref = written.Digest.String()
+		}
+		fmt.Fprintf(nc.opts.Progress, "Writing manifest %s\n", written.Digest)
+		if _, err := nc.dest.PutManifestBytes(ref, written.MediaType, content); err != nil {
+			return ispec.Descriptor{}, fmt.Errorf("Failed to put manifest '%s': %s", written.Digest, err)
+		}
+		nc.report(pushed, written, ref)
+	}
+	nc.markCopied(desc.Digest, written)
+
+	if nc.opts.Referrers {
+		return written, nc.copyReferrers(desc)
+	}
+	return written, nil
+}
+
+// editManifest replaces top level fields of a manifest or index, keeping
+// every other field as it was.
+func editManifest(content []byte, edits map[string]interface{}) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return []byte{}, err
+	}
+	for name, value := range edits {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return []byte{}, err
+		}
+		fields[name] = raw
+	}
+	return json.Marshal(fields)
+}
+
+func (nc *nativeCopy) copyReferrers(subject ispec.Descriptor) error {
+	refs, err := nc.src.GetReferrers(&subject)
+	if err != nil {
+		return fmt.Errorf("Failed to get referrers of '%s': %s", subject.Digest, err)
+	}
+
+	for _, referrer := range refs.Manifests {
+		if _, ok := nc.copiedAs(referrer.Digest); ok || !nc.artifactTypeAllowed(referrer.ArtifactType) {
+			continue
+		}
+
+		mediaType, content, err := nc.src.GetManifestBytes(referrer.Digest.String())
+		if err != nil {
+			return fmt.Errorf("Failed to get referrer '%s': %s", referrer.Digest, err)
+		}
+		referrer.MediaType = mediaType
+
+		fmt.Fprintf(nc.opts.Progress, "Copying referrer %s %s\n", referrer.Digest, referrer.ArtifactType)
+		if _, err := nc.copyManifest(referrer, content, "", progress.ReferrerPushed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nc *nativeCopy) copiedAs(dgst digest.Digest) (ispec.Descriptor, bool) {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	written, ok := nc.copied[dgst]
+	return written, ok
+}
+
+func (nc *nativeCopy) markCopied(dgst digest.Digest, written ispec.Descriptor) {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	nc.copied[dgst] = written
+}
+
+// copyBlob transfers a blob unless it was already copied or exists at the
+// destination.  Concurrent calls for the same digest share one transfer.
+func (nc *nativeCopy) copyBlob(desc ispec.Descriptor) error {
+	_, err, _ := nc.inflight.Do(desc.Digest.String(), func() (interface{}, error) {
+		if _, ok := nc.copiedAs(desc.Digest); ok {
+			return nil, nil
+		}
+
+		if nc.plan != nil {
+			nc.planBlob(desc, "")
+			nc.markCopied(desc.Digest, desc)
+			return nil, nil
+		}
+
+		if nc.destHasBlob(desc) || nc.mountBlob(desc) {
+			nc.markCopied(desc.Digest, desc)
+			return nil, nil
+		}
+
+		fmt.Fprintf(nc.opts.Progress, "Copying blob %s\n", desc.Digest)
+		nc.report(progress.BlobStart, desc, "")
+
+		nc.srcSem <- struct{}{}
+		blob, err := nc.src.GetBlob(&desc)
+		<-nc.srcSem
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get blob '%s': %s", desc.Digest, err)
+		}
+
+		if dgst := digest.FromBytes(blob); dgst != desc.Digest {
+			return nil, fmt.Errorf("Blob content does not match digest '%s'", desc.Digest)
+		}
+
+		if err := nc.putBlob(desc, bytes.NewReader(blob)); err != nil {
+			return nil, err
+		}
+
+		nc.markCopied(desc.Digest, desc)
+		return nil, nil
+	})
+	return err
+}
+
+// putBlob writes desc, read from reader, to the destination, streaming it to
+// destinations that take a reader
+func (nc *nativeCopy) putBlob(desc ispec.Descriptor, reader io.Reader) error {
+	nc.destSem <- struct{}{}
+	defer func() { <-nc.destSem }()
+
+	var err error
+	if writer, ok := nc.dest.(BlobWriter); ok {
+		if nc.opts.Reporter != nil {
+			reader = &progressReader{nc: nc, desc: desc, reader: reader, last: time.Now()}
+		}
+		err = writer.PutBlobReader(&desc, reader)
+	} else {
+		var blob []byte
+		if blob, err = io.ReadAll(reader); err == nil {
+			err = nc.dest.PutBlob(&desc, blob)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to put blob '%s': %s", desc.Digest, err)
+	}
+	nc.report(progress.BlobDone, desc, "")
+	return nil
+}
+
+func (nc *nativeCopy) destHasBlob(desc ispec.Descriptor) bool {
+	nc.destSem <- struct{}{}
+	err := nc.dest.BlobHead(&desc)
+	<-nc.destSem
+	if err != nil {
+		return false
+	}
+	log.Debugf("NativeCopy() blob %s already exists", desc.Digest)
+	fmt.Fprintf(nc.opts.Progress, "Skipping blob %s (already present)\n", desc.Digest)
+	nc.report(progress.BlobSkipped, desc, "")
+	return true
+}
+
+// mountBlob links a blob from the source repository when it is another
+// repository of the destination's registry
+func (nc *nativeCopy) mountBlob(desc ispec.Descriptor) bool {
+	mounter, ok := nc.dest.(BlobMounter)
+	if !ok || registryKey(nc.src) != registryKey(nc.dest) || nc.src.RepoPath() == nc.dest.RepoPath() {
+		return false
+	}
+
+	nc.destSem <- struct{}{}
+	mounted, err := mounter.MountBlob(&desc, nc.src.RepoPath())
+	<-nc.destSem
+	if err != nil || !mounted {
+		log.WithFields(log.Fields{
+			"digest": desc.Digest,
+			"err":    err,
+		}).Debug("NativeCopy() blob not mounted")
+		return false
+	}
+
+	fmt.Fprintf(nc.opts.Progress, "Mounted blob %s from %s\n", desc.Digest, nc.src.RepoPath())
+	nc.report(progress.BlobMounted, desc, "")
+	return true
+}
+
+// convertLayer copies a layer recompressed as opts.Compression and returns
+// the descriptor of the new layer.
+func (nc *nativeCopy) convertLayer(desc ispec.Descriptor) (ispec.Descriptor, error) {
+	converted, err, _ := nc.inflight.Do("convert "+desc.Digest.String(), func() (interface{}, error) {
+		if written, ok := nc.copiedAs(desc.Digest); ok {
+			return written, nil
+		}
+
+		// the recompressed digest is unknown without recompressing
+		if nc.plan != nil {
+			nc.planBlob(desc, nc.opts.Compression)
+			written := desc
+			written.MediaType = layerCompressionMediaTypes[nc.opts.Compression]
+			nc.markCopied(desc.Digest, written)
+			return written, nil
+		}
+
+		nc.report(progress.BlobStart, desc, "")
+		nc.srcSem <- struct{}{}
+		blob, err := nc.src.GetBlob(&desc)
+		<-nc.srcSem
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get blob '%s': %s", desc.Digest, err)
+		}
+
+		if dgst := digest.FromBytes(blob); dgst != desc.Digest {
+			return nil, fmt.Errorf("Blob content does not match digest '%s'", desc.Digest)
+		}
+
+		// the digest of the recompressed layer is needed before writing it,
+		// so it is spooled to a temporary file rather than held in memory
+		converted, err := recompress(bytes.NewReader(blob), nc.opts.Compression, nc.opts.CompressionLevel)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to recompress blob '%s': %s", desc.Digest, err)
+		}
+		defer converted.Close()
+
+		tmp, err := os.CreateTemp("", "ocidist-layer-")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create temporary file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		digester := digest.Canonical.Digester()
+		size, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), converted)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to recompress blob '%s': %s", desc.Digest, err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("Failed to rewind recompressed blob '%s': %s", desc.Digest, err)
+		}
+
+		written := desc
+		written.MediaType = layerCompressionMediaTypes[nc.opts.Compression]
+		written.Digest = digester.Digest()
+		written.Size = size
+		fmt.Fprintf(nc.opts.Progress, "Converted blob %s to %s %s\n", desc.Digest, nc.opts.Compression, written.Digest)
+
+		if !nc.destHasBlob(written) {
+			if err := nc.putBlob(written, tmp); err != nil {
+				return nil, err
+			}
+		}
+
+		nc.markCopied(desc.Digest, written)
+		return written, nil
+	})
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+	return converted.(ispec.Descriptor), nil
+}
+
+// report sends an event about desc, a blob or a manifest written to ref
+func (nc *nativeCopy) report(event string, desc ispec.Descriptor, ref string) {
+	ev := progress.Event{
+		Type:         event,
+		Digest:       desc.Digest,
+		MediaType:    desc.MediaType,
+		ArtifactType: desc.ArtifactType,
+		Size:         desc.Size,
+		Ref:          ref,
+	}
+	if event == progress.BlobDone {
+		ev.Offset = desc.Size
+	}
+	progress.Send(nc.opts.Reporter, ev)
+}
+
+// progressReader reports the bytes of a blob read so far as BlobProgress
+// events while the destination uploads it
+type progressReader struct {
+	nc     *nativeCopy
+	desc   ispec.Descriptor
+	reader io.Reader
+	offset int64
+	last   time.Time
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.offset += int64(n)
+	if n > 0 && pr.offset < pr.desc.Size && time.Since(pr.last) >= blobProgressInterval {
+		pr.last = time.Now()
+		progress.Send(pr.nc.opts.Reporter, progress.Event{
+			Type:         progress.BlobProgress,
+			Digest:       pr.desc.Digest,
+			MediaType:    pr.desc.MediaType,
+			ArtifactType: pr.desc.ArtifactType,
+			Size:         pr.desc.Size,
+			Offset:       pr.offset,
+		})
+	}
+	return n, err
+}
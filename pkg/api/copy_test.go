@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	linuxAmd64 = ispec.Platform{OS: "linux", Architecture: "amd64"}
+	linuxArm64 = ispec.Platform{OS: "linux", Architecture: "arm64"}
+)
+
+func TestCopyPlatformsOfIndexWithReferrers(t *testing.T) {
+	src := apitest.NewMemRepo(t, "src/img:v1")
+	apitest.PushIndex(t, src, linuxAmd64, linuxArm64)
+	if err := src.PutArtifact("sig", "application/vnd.example.sig", []byte("signature")); err != nil {
+		t.Fatalf("Failed to put artifact: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		referrers     bool
+		artifactTypes []string
+		fails         bool
+	}{
+		// the signature would name the unfiltered index as its subject
+		{name: "referrers", referrers: true, fails: true},
+		{name: "no-referrers", referrers: false},
+		{name: "other-referrers", referrers: true, artifactTypes: []string{"application/vnd.example.sbom"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := apitest.NewMemRepo(t, "dest/img:"+tc.name)
+			err := api.NativeCopy(src, dest, api.NativeCopyOpts{
+				Referrers:     tc.referrers,
+				ArtifactTypes: tc.artifactTypes,
+				Platforms:     []ispec.Platform{linuxAmd64},
+			})
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("Copy of platforms with the index's referrers succeeded")
+				}
+				if _, _, err := dest.GetManifestBytes(dest.RepoTag()); err == nil {
+					t.Errorf("Refused copy wrote the destination tag")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to copy: %s", err)
+			}
+
+			mediaType, _, err := dest.GetManifestBytes(dest.RepoTag())
+			if err != nil {
+				t.Fatalf("Failed to get copied index: %s", err)
+			}
+			if mediaType != ispec.MediaTypeImageIndex {
+				t.Errorf("Copied %s, expected an index", mediaType)
+			}
+		})
+	}
+}
+
+func TestCopyDockerManifestUncompressed(t *testing.T) {
+	src := apitest.NewMemRepo(t, "src/img:v1")
+	tarball := []byte("layer tar")
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(tarball)
+	gz.Close()
+
+	config := apitest.PutBlob(t, src, api.MediaTypeDockerConfig, []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`))
+	layer := apitest.PutBlob(t, src, api.MediaTypeDockerLayer, gzipped.Bytes())
+	apitest.PutManifest(t, src, "v1", api.MediaTypeDockerManifest, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     api.MediaTypeDockerManifest,
+		"config":        config,
+		"layers":        []ispec.Descriptor{layer},
+	})
+
+	for _, tc := range []struct {
+		compression string
+		layerType   string
+		content     []byte
+	}{
+		{compression: "none", layerType: ispec.MediaTypeImageLayer, content: tarball},
+		// docker layers are already gzip, only their media type changes
+		{compression: "gzip", layerType: ispec.MediaTypeImageLayerGzip, content: gzipped.Bytes()},
+	} {
+		t.Run(tc.compression, func(t *testing.T) {
+			dest := apitest.NewMemRepo(t, "dest/img:v1")
+			if err := api.NativeCopy(src, dest, api.NativeCopyOpts{Compression: tc.compression}); err != nil {
+				t.Fatalf("Failed to copy: %s", err)
+			}
+
+			mediaType, content, err := dest.GetManifestBytes(dest.RepoTag())
+			if err != nil {
+				t.Fatalf("Failed to get copied manifest: %s", err)
+			}
+			var manifest ispec.Manifest
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				t.Fatalf("Failed to parse copied manifest: %s", err)
+			}
+			if mediaType != ispec.MediaTypeImageManifest || manifest.MediaType != ispec.MediaTypeImageManifest {
+				t.Errorf("Copied manifest has media type %s %s, expected an OCI manifest", mediaType, manifest.MediaType)
+			}
+			if manifest.Config.MediaType != ispec.MediaTypeImageConfig || manifest.Config.Digest != config.Digest {
+				t.Errorf("Copied config is %s %s, expected the OCI config %s", manifest.Config.MediaType, manifest.Config.Digest, config.Digest)
+			}
+			if len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != tc.layerType {
+				t.Fatalf("Copied layers %v, expected one %s layer", manifest.Layers, tc.layerType)
+			}
+			blob, err := dest.GetBlob(&manifest.Layers[0])
+			if err != nil {
+				t.Fatalf("Failed to get copied layer: %s", err)
+			}
+			if !bytes.Equal(blob, tc.content) {
+				t.Errorf("Copied layer has %q, expected %q", blob, tc.content)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const dockerArchiveManifestFile = "manifest.json"
+
+// dockerArchiveEntry is one image in a docker save manifest.json
+type dockerArchiveEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// dockerArchiveImage is an image from a docker save archive converted to an
+// OCI manifest, with the archive member backing each blob digest.
+type dockerArchiveImage struct {
+	manifest       []byte
+	config         []byte
+	configDigest   digest.Digest
+	layerFiles     map[digest.Digest]string
+	manifestDigest digest.Digest
+}
+
+// DockerArchiveRepo reads images from a docker save tarball, presenting each
+// as an OCI image manifest with uncompressed tar layers.  It is read-only.
+type DockerArchiveRepo struct {
+	url    *url.URL
+	config *OCIAPIConfig
+
+	// the index of the archive and the converted image, made once
+	lock  sync.Mutex
+	index *tarIndex
+	image *dockerArchiveImage
+}
+
+func NewDockerArchiveRepo(url *url.URL, config *OCIAPIConfig) (*DockerArchiveRepo, error) {
+	return &DockerArchiveRepo{url: url, config: config}, nil
+}
+
+func (dar *DockerArchiveRepo) Type() OCIRepoType {
+	return DockerArchiveRepoType
+}
+
+// docker-archive:///home/ubuntu/images/vendor.tar:myimage:v2.1
+// docker-archive:///home/ubuntu/images/vendor.tar:@1
+//
+//	archive = host + path - (:ref)
+//	ref = a RepoTag from manifest.json, or @ and the image's index in it
+func (dar *DockerArchiveRepo) ArchivePath() string {
+	archive, _, _ := strings.Cut(dar.url.Path, ":")
+	return filepath.Join(dar.url.Host, archive)
+}
+
+func (dar *DockerArchiveRepo) RepoPath() string {
+	return dar.ArchivePath()
+}
+
+func (dar *DockerArchiveRepo) RepoTag() string {
+	_, ref, _ := strings.Cut(dar.url.Path, ":")
+	return ref
+}
+
+func (dar *DockerArchiveRepo) ImageName() string {
+	return strings.TrimSuffix(filepath.Base(dar.ArchivePath()), ".tar")
+}
+
+func (dar *DockerArchiveRepo) SourceURL() string {
+	return dar.url.String()
+}
+
+func (dar *DockerArchiveRepo) getEntries(ti *tarIndex) ([]dockerArchiveEntry, error) {
+	manifestBytes, err := ti.ReadFile(dockerArchiveManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read docker archive manifest: %s", err)
+	}
+
+	var entries []dockerArchiveEntry
+	if err := json.Unmarshal(manifestBytes, &entries); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal docker archive manifest from %q: %s", ti.path, err)
+	}
+
+	// archives from older docker only record tags in the repositories file,
+	// keyed by the id of each image's top layer
+	repositoriesBytes, err := ti.ReadFile("repositories")
+	if err != nil {
+		return entries, nil
+	}
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(repositoriesBytes, &repositories); err != nil {
+		log.Debugf("DockerArchive ignoring unparseable repositories file: %s", err)
+		return entries, nil
+	}
+	for i, entry := range entries {
+		if len(entry.RepoTags) > 0 || len(entry.Layers) == 0 {
+			continue
+		}
+		topLayer := path.Dir(tarName(entry.Layers[len(entry.Layers)-1]))
+		for name, tags := range repositories {
+			for tag, layerID := range tags {
+				if layerID == topLayer {
+					entries[i].RepoTags = append(entries[i].RepoTags, fmt.Sprintf("%s:%s", name, tag))
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (dar *DockerArchiveRepo) GetRepoTagList() (*dspec.TagList, error) {
+	tagList := dspec.TagList{Tags: []string{}}
+
+	// if URI is pointing to an image, no RepoTags are represent
+	if len(dar.RepoTag()) > 0 {
+		return &tagList, nil
+	}
+
+	tagList.Name = dar.ImageName()
+	tags, err := dar.GetRepoTags()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get repo tags: %s", err)
+	}
+
+	tagList.Tags = tags
+	return &tagList, nil
+}
+
+// open returns the index of the archive, which is read-only
+func (dar *DockerArchiveRepo) open() (*tarIndex, error) {
+	if dar.index == nil {
+		ti, err := openTarIndex(dar.ArchivePath(), nil)
+		if err != nil {
+			return nil, err
+		}
+		dar.index = ti
+	}
+	return dar.index, nil
+}
+
+func (dar *DockerArchiveRepo) GetRepoTags() ([]string, error) {
+	dar.lock.Lock()
+	ti, err := dar.open()
+	dar.lock.Unlock()
+	if err != nil {
+		return []string{}, err
+	}
+
+	entries, err := dar.getEntries(ti)
+	if err != nil {
+		return []string{}, err
+	}
+
+	tags := []string{}
+	for _, entry := range entries {
+		tags = append(tags, entry.RepoTags...)
+	}
+	return tags, nil
+}
+
+func (dar *DockerArchiveRepo) GetRepositories() ([]string, error) {
+	return []string{dar.ArchivePath()}, nil
+}
+
+// selectEntry returns the manifest.json entry matching ref, or the only
+// image in the archive if ref is empty.
+func (dar *DockerArchiveRepo) selectEntry(entries []dockerArchiveEntry, ref string) (*dockerArchiveEntry, error) {
+	if ref == "" {
+		if len(entries) != 1 {
+			return nil, fmt.Errorf("Archive %q contains %d images, a reference is required", dar.ArchivePath(), len(entries))
+		}
+		return &entries[0], nil
+	}
+
+	if strings.HasPrefix(ref, "@") {
+		index, err := strconv.Atoi(ref[1:])
+		if err != nil || index < 0 || index >= len(entries) {
+			return nil, fmt.Errorf("Invalid image index '%s' for archive %q with %d images", ref, dar.ArchivePath(), len(entries))
+		}
+		return &entries[index], nil
+	}
+
+	for i, entry := range entries {
+		for _, repoTag := range entry.RepoTags {
+			if repoTag == ref {
+				return &entries[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Image '%s' in archive %q %w", ref, dar.ArchivePath(), ErrNotFound)
+}
+
+// layerMediaType sniffs a layer's compression from its first bytes
+func layerMediaType(ti *tarIndex, name string) (string, error) {
+	reader, err := ti.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(reader, magic)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return ispec.MediaTypeImageLayerGzip, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return ispec.MediaTypeImageLayerZstd, nil
+	}
+	return ispec.MediaTypeImageLayer, nil
+}
+
+// loadImage converts the selected image to an OCI manifest, once.
+func (dar *DockerArchiveRepo) loadImage() (*dockerArchiveImage, *tarIndex, error) {
+	dar.lock.Lock()
+	defer dar.lock.Unlock()
+
+	if dar.image != nil {
+		return dar.image, dar.index, nil
+	}
+	ti, err := dar.open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := dar.getEntries(ti)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := dar.selectEntry(entries, dar.RepoTag())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configBytes, err := ti.ReadFile(entry.Config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read image config: %s", err)
+	}
+
+	var img ispec.Image
+	if err := json.Unmarshal(configBytes, &img); err != nil {
+		return nil, nil, fmt.Errorf("Failed to unmarshal image config '%s': %s", entry.Config, err)
+	}
+
+	image := &dockerArchiveImage{
+		config:       configBytes,
+		configDigest: digest.FromBytes(configBytes),
+		layerFiles:   map[digest.Digest]string{},
+	}
+
+	manifest := ispec.Manifest{
+		Versioned: ManifestV2,
+		MediaType: ispec.MediaTypeImageManifest,
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    image.configDigest,
+			Size:      int64(len(configBytes)),
+		},
+		Layers: []ispec.Descriptor{},
+	}
+
+	for i, layerFile := range entry.Layers {
+		layerEntry, err := ti.lookup(layerFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to find layer: %s", err)
+		}
+
+		mediaType, err := layerMediaType(ti, layerFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read layer '%s': %s", layerFile, err)
+		}
+
+		// uncompressed layers are named by their diff_id, others must be hashed
+		var layerDigest digest.Digest
+		if mediaType == ispec.MediaTypeImageLayer && i < len(img.RootFS.DiffIDs) {
+			layerDigest = img.RootFS.DiffIDs[i]
+		} else {
+			reader, err := ti.Open(layerFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			layerDigest, err = digest.FromReader(reader)
+			reader.Close()
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to hash layer '%s': %s", layerFile, err)
+			}
+		}
+
+		image.layerFiles[layerDigest] = layerFile
+		manifest.Layers = append(manifest.Layers, ispec.Descriptor{
+			MediaType: mediaType,
+			Digest:    layerDigest,
+			Size:      layerEntry.size,
+		})
+	}
+
+	if len(entry.RepoTags) > 0 {
+		manifest.Annotations = map[string]string{ispec.AnnotationRefName: entry.RepoTags[0]}
+	}
+
+	image.manifest, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to marshal manifest: %s", err)
+	}
+	image.manifestDigest = digest.FromBytes(image.manifest)
+
+	log.WithFields(log.Fields{
+		"archive":  ti.path,
+		"config":   entry.Config,
+		"repoTags": entry.RepoTags,
+		"digest":   image.manifestDigest,
+	}).Debug("DockerArchive.loadImage converted image")
+
+	dar.image = image
+	return image, ti, nil
+}
+
+// GetManifestBytes returns the converted manifest of the selected image, by
+// its reference or digest.
+func (dar *DockerArchiveRepo) GetManifestBytes(ref string) (string, []byte, error) {
+	image, ti, err := dar.loadImage()
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	if dgst, err := digest.Parse(ref); err == nil && dgst != image.manifestDigest {
+		return "", []byte{}, fmt.Errorf("Manifest '%s' in archive %q %w", ref, ti.path, ErrNotFound)
+	}
+	return ispec.MediaTypeImageManifest, image.manifest, nil
+}
+
+func (dar *DockerArchiveRepo) GetManifest() (*ispec.Manifest, []byte, error) {
+	image, _, err := dar.loadImage()
+	if err != nil {
+		return &ispec.Manifest{}, []byte{}, err
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(image.manifest, &manifest); err != nil {
+		return &ispec.Manifest{}, []byte{}, fmt.Errorf("Failed to unmarshal manifest: %s", err)
+	}
+	return &manifest, image.manifest, nil
+}
+
+func (dar *DockerArchiveRepo) GetImage(config *ispec.Descriptor) (*ispec.Image, error) {
+	configBytes, err := dar.GetBlob(config)
+	if err != nil {
+		return &ispec.Image{}, err
+	}
+
+	var img ispec.Image
+	if err := json.Unmarshal(configBytes, &img); err != nil {
+		return &ispec.Image{}, fmt.Errorf("Failed to unmarshal image config: %s", err)
+	}
+	return &img, nil
+}
+
+// GetReferrers always returns an empty index, docker archives have no way to
+// record referrers.
+func (dar *DockerArchiveRepo) GetReferrers(image *ispec.Descriptor) (*ispec.Index, error) {
+	return &ispec.Index{
+		Versioned: ManifestV2,
+		MediaType: ispec.MediaTypeImageIndex,
+		Manifests: []ispec.Descriptor{},
+	}, nil
+}
+
+func (dar *DockerArchiveRepo) GetBlob(layer *ispec.Descriptor) ([]byte, error) {
+	image, ti, err := dar.loadImage()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	switch layer.Digest {
+	case image.manifestDigest:
+		return image.manifest, nil
+	case image.configDigest:
+		return image.config, nil
+	}
+
+	layerFile, ok := image.layerFiles[layer.Digest]
+	if !ok {
+		return []byte{}, fmt.Errorf("Blob '%s' in archive %q %w", layer.Digest, ti.path, ErrNotFound)
+	}
+	return ti.ReadFile(layerFile)
+}
+
+func (dar *DockerArchiveRepo) BlobHead(layer *ispec.Descriptor) error {
+	image, ti, err := dar.loadImage()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := image.layerFiles[layer.Digest]; !ok && layer.Digest != image.configDigest {
+		return fmt.Errorf("Blob '%s' in archive %q %w", layer.Digest, ti.path, ErrNotFound)
+	}
+	return nil
+}
+
+func (dar *DockerArchiveRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
+	return fmt.Errorf("docker-archive is read-only")
+}
+
+func (dar *DockerArchiveRepo) PutManifest(manifest *ispec.Manifest) (digest.Digest, error) {
+	return "", fmt.Errorf("docker-archive is read-only")
+}
+
+func (dar *DockerArchiveRepo) PutManifestBytes(ref, mediaType string, content []byte) (digest.Digest, error) {
+	return "", fmt.Errorf("docker-archive is read-only")
+}
+
+func (dar *DockerArchiveRepo) DeleteManifest(ref string) error {
+	return fmt.Errorf("docker-archive is read-only")
+}
+
+func (dar *DockerArchiveRepo) PutArtifact(artifactName, artifactType string, artifactBlob []byte) error {
+	return fmt.Errorf("docker-archive is read-only")
+}
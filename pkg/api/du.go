@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+type TagUsage struct {
+	Tag    string        `json:"tag"`
+	Digest digest.Digest `json:"digest"`
+	// bytes of every manifest, config and layer of the tag
+	Total int64 `json:"total"`
+	// bytes of the blobs no other tag uses
+	Unique int64 `json:"unique"`
+	Shared int64 `json:"shared"`
+	Blobs  int   `json:"blobs"`
+}
+
+// UsageReport is the size of each tag of a repository or layout and of the
+// blobs they hold between them.
+type UsageReport struct {
+	Source string     `json:"source"`
+	Tags   []TagUsage `json:"tags"`
+	// bytes of the distinct blobs of every tag, each counted once
+	Total int64 `json:"total"`
+	Blobs int   `json:"blobs"`
+	// bytes of every tag summed, shared blobs counted once per tag
+	Logical int64 `json:"logical"`
+}
+
+// usageWalk remembers the blobs of each manifest walked, as tags often point
+// at the same manifests.
+type usageWalk struct {
+	api       OCIAPI
+	manifests map[digest.Digest]map[digest.Digest]int64
+}
+
+// blobs returns the sizes of content's manifest and of every blob it refers
+// to, through the manifests of an index.
+func (uw *usageWalk) blobs(content []byte) (map[digest.Digest]int64, error) {
+	dgst := digest.FromBytes(content)
+	if blobs, ok := uw.manifests[dgst]; ok {
+		return blobs, nil
+	}
+
+	var doc struct {
+		Config    *ispec.Descriptor  `json:"config"`
+		Layers    []ispec.Descriptor `json:"layers"`
+		Manifests []ispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest '%s': %s", dgst, err)
+	}
+
+	blobs := map[digest.Digest]int64{dgst: int64(len(content))}
+	if doc.Config != nil {
+		blobs[doc.Config.Digest] = doc.Config.Size
+	}
+	for _, layer := range doc.Layers {
+		blobs[layer.Digest] = layer.Size
+	}
+	for _, child := range doc.Manifests {
+		_, childContent, err := uw.api.GetManifestBytes(child.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get manifest '%s': %s", child.Digest, err)
+		}
+		childBlobs, err := uw.blobs(childContent)
+		if err != nil {
+			return nil, err
+		}
+		for d, size := range childBlobs {
+			blobs[d] = size
+		}
+	}
+	uw.manifests[dgst] = blobs
+	return blobs, nil
+}
+
+// DiskUsage walks the manifests of every tag of the repository or layout at
+// rawURL and reports how many bytes each tag uses, alone and with others.
+func DiskUsage(rawURL string, config *OCIAPIConfig) (*UsageReport, error) {
+	if config == nil {
+		config = &OCIAPIConfig{}
+	}
+	ociApi, err := NewOCIAPI(rawURL, config)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := syncTags(ociApi)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list tags: %s", err)
+	}
+	sort.Strings(tags)
+
+	uw := &usageWalk{api: ociApi, manifests: map[digest.Digest]map[digest.Digest]int64{}}
+	tagBlobs := map[string]map[digest.Digest]int64{}
+	// how many tags use each blob
+	users := map[digest.Digest]int{}
+	sizes := map[digest.Digest]int64{}
+
+	report := &UsageReport{Source: rawURL, Tags: []TagUsage{}}
+	for _, tag := range tags {
+		log.WithFields(log.Fields{
+			"url": rawURL,
+			"tag": tag,
+		}).Debug("DiskUsage() walking tag")
+
+		tagApi, err := NewOCIAPI(fmt.Sprintf("%s:%s", rawURL, tag), config)
+		if err != nil {
+			return nil, err
+		}
+		_, content, err := tagApi.GetManifestBytes(tagApi.RepoTag())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get manifest of tag '%s': %s", tag, err)
+		}
+		uw.api = tagApi
+		blobs, err := uw.blobs(content)
+		if err != nil {
+			return nil, err
+		}
+
+		tagBlobs[tag] = blobs
+		for d, size := range blobs {
+			users[d]++
+			sizes[d] = size
+		}
+		report.Tags = append(report.Tags, TagUsage{Tag: tag, Digest: digest.FromBytes(content), Blobs: len(blobs)})
+	}
+
+	for i := range report.Tags {
+		usage := &report.Tags[i]
+		for d, size := range tagBlobs[usage.Tag] {
+			usage.Total += size
+			if users[d] == 1 {
+				usage.Unique += size
+			}
+		}
+		usage.Shared = usage.Total - usage.Unique
+		report.Logical += usage.Total
+	}
+	for _, size := range sizes {
+		report.Total += size
+	}
+	report.Blobs = len(sizes)
+	return report, nil
+}
+
+func (ur *UsageReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Usage of %s\n", ur.Source)
+	for _, t := range ur.Tags {
+		fmt.Fprintf(w, "  %-20s %10s total %10s unique %10s shared  %d blobs\n", t.Tag,
+			units.BytesSize(float64(t.Total)), units.BytesSize(float64(t.Unique)),
+			units.BytesSize(float64(t.Shared)), t.Blobs)
+	}
+	fmt.Fprintf(w, "%d tags, %d blobs, %s stored, %s without sharing\n", len(ur.Tags), ur.Blobs,
+		units.BytesSize(float64(ur.Total)), units.BytesSize(float64(ur.Logical)))
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// mem://<store>/<repo path>:<tag>
+//
+// Every MemRepo with the same store name shares content for the lifetime of
+// the process, so one MemRepo can PUT what another one later GETs.
+var memStores = struct {
+	lock   sync.Mutex
+	stores map[string]*memStore
+}{stores: map[string]*memStore{}}
+
+type memStore struct {
+	lock  sync.Mutex
+	repos map[string]*memRepository
+}
+
+type memManifest struct {
+	mediaType string
+	content   []byte
+}
+
+type memRepository struct {
+	tags      map[string]digest.Digest
+	manifests map[digest.Digest]memManifest
+	blobs     map[digest.Digest][]byte
+}
+
+func getMemStore(name string) *memStore {
+	memStores.lock.Lock()
+	defer memStores.lock.Unlock()
+
+	store, ok := memStores.stores[name]
+	if !ok {
+		store = &memStore{repos: map[string]*memRepository{}}
+		memStores.stores[name] = store
+	}
+	return store
+}
+
+// DeleteMemStore drops all content in the named mem:// store.
+func DeleteMemStore(name string) {
+	memStores.lock.Lock()
+	defer memStores.lock.Unlock()
+	delete(memStores.stores, name)
+}
+
+type MemRepo struct {
+	url    *url.URL
+	config *OCIAPIConfig
+	store  *memStore
+}
+
+func NewMemRepo(url *url.URL, config *OCIAPIConfig) (*MemRepo, error) {
+	return &MemRepo{url: url, config: config, store: getMemStore(url.Host)}, nil
+}
+
+func (mr *MemRepo) Type() OCIRepoType {
+	return MemRepoType
+}
+
+func (mr *MemRepo) RepoPath() string {
+	path, _, _ := strings.Cut(mr.url.Path, ":")
+	return strings.TrimLeft(path, "/")
+}
+
+func (mr *MemRepo) RepoTag() string {
+	_, tag, _ := strings.Cut(mr.url.Path, ":")
+	return tag
+}
+
+func (mr *MemRepo) SourceURL() string {
+	return mr.url.String()
+}
+
+func (mr *MemRepo) ImageName() string {
+	return filepath.Join(mr.url.Host, mr.RepoPath())
+}
+
+// repo returns the repository for this URL, creating it if create is set.
+// The caller must hold the store lock.
+func (mr *MemRepo) repo(create bool) (*memRepository, error) {
+	repoPath := mr.RepoPath()
+	repo, ok := mr.store.repos[repoPath]
+	if !ok {
+		if !create {
+			return nil, fmt.Errorf("Repository '%s' %w", repoPath, ErrNotFound)
+		}
+		repo = &memRepository{
+			tags:      map[string]digest.Digest{},
+			manifests: map[digest.Digest]memManifest{},
+			blobs:     map[digest.Digest][]byte{},
+		}
+		mr.store.repos[repoPath] = repo
+	}
+	return repo, nil
+}
+
+func (mr *MemRepo) GetRepoTagList() (*dspec.TagList, error) {
+	tags, err := mr.GetRepoTags()
+	if err != nil {
+		return nil, err
+	}
+	return &dspec.TagList{Name: mr.RepoPath(), Tags: tags}, nil
+}
+
+func (mr *MemRepo) GetRepoTags() ([]string, error) {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, err := mr.repo(false)
+	if err != nil {
+		return []string{}, err
+	}
+
+	tags := []string{}
+	for tag := range repo.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (mr *MemRepo) GetRepositories() ([]string, error) {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repos := []string{}
+	for name := range mr.store.repos {
+		repos = append(repos, name)
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// GetManifestBytes returns the media type and content of the manifest at
+// ref, a tag or digest.
+func (mr *MemRepo) GetManifestBytes(ref string) (string, []byte, error) {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, err := mr.repo(false)
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		tagDigest, ok := repo.tags[ref]
+		if !ok {
+			return "", []byte{}, fmt.Errorf("Manifest '%s' %w", ref, ErrNotFound)
+		}
+		dgst = tagDigest
+	}
+
+	manifest, ok := repo.manifests[dgst]
+	if !ok {
+		return "", []byte{}, fmt.Errorf("Manifest '%s' %w", ref, ErrNotFound)
+	}
+	return manifest.mediaType, manifest.content, nil
+}
+
+func (mr *MemRepo) GetManifest() (*ispec.Manifest, []byte, error) {
+	_, manifestBytes, err := mr.GetManifestBytes(mr.RepoTag())
+	if err != nil {
+		return nil, []byte{}, err
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, []byte{}, fmt.Errorf("Failed to unmarshal manifest: %s", err)
+	}
+	return &manifest, manifestBytes, nil
+}
+
+func (mr *MemRepo) ManifestHead() error {
+	_, _, err := mr.GetManifestBytes(mr.RepoTag())
+	return err
+}
+
+func (mr *MemRepo) GetImage(config *ispec.Descriptor) (*ispec.Image, error) {
+	configBytes, err := mr.GetBlob(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var img ispec.Image
+	if err := json.Unmarshal(configBytes, &img); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal image config: %s", err)
+	}
+	return &img, nil
+}
+
+func (mr *MemRepo) GetReferrers(image *ispec.Descriptor) (*ispec.Index, error) {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	refs := ispec.Index{
+		Versioned: ManifestV2,
+		MediaType: ispec.MediaTypeImageIndex,
+		Manifests: []ispec.Descriptor{},
+	}
+
+	repo, err := mr.repo(false)
+	if err != nil {
+		// like a registry, an unknown subject has no referrers
+		return &refs, nil
+	}
+
+	for dgst, stored := range repo.manifests {
+		var manifest ispec.Manifest
+		if err := json.Unmarshal(stored.content, &manifest); err != nil {
+			continue
+		}
+		if manifest.Subject == nil || manifest.Subject.Digest != image.Digest {
+			continue
+		}
+		refs.Manifests = append(refs.Manifests, ispec.Descriptor{
+			MediaType:    stored.mediaType,
+			ArtifactType: manifest.ArtifactType,
+			Digest:       dgst,
+			Size:         int64(len(stored.content)),
+			Annotations:  manifest.Annotations,
+		})
+	}
+
+	// map iteration order is random, keep results stable
+	sort.Slice(refs.Manifests, func(i, j int) bool {
+		return refs.Manifests[i].Digest < refs.Manifests[j].Digest
+	})
+
+	return &refs, nil
+}
+
+// GetBlob returns blob content, manifests may also be fetched as blobs as
+// most registries allow.
+func (mr *MemRepo) GetBlob(layer *ispec.Descriptor) ([]byte, error) {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, err := mr.repo(false)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if blob, ok := repo.blobs[layer.Digest]; ok {
+		return blob, nil
+	}
+	if manifest, ok := repo.manifests[layer.Digest]; ok {
+		return manifest.content, nil
+	}
+	return []byte{}, fmt.Errorf("Blob '%s' %w", layer.Digest, ErrNotFound)
+}
+
+func (mr *MemRepo) BlobHead(layer *ispec.Descriptor) error {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, err := mr.repo(false)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := repo.blobs[layer.Digest]; !ok {
+		return fmt.Errorf("Blob '%s' %w", layer.Digest, ErrNotFound)
+	}
+	return nil
+}
+
+// MountBlob links layer from repository fromRepo of the same store
+func (mr *MemRepo) MountBlob(layer *ispec.Descriptor, fromRepo string) (bool, error) {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	from, ok := mr.store.repos[fromRepo]
+	if !ok {
+		return false, nil
+	}
+	blob, ok := from.blobs[layer.Digest]
+	if !ok {
+		return false, nil
+	}
+
+	repo, _ := mr.repo(true)
+	repo.blobs[layer.Digest] = blob
+	return true, nil
+}
+
+func (mr *MemRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
+	log.WithFields(log.Fields{
+		"layer":    layer,
+		"blobSize": len(blob),
+	}).Debug("Mem.PutBlob() called")
+
+	if dgst := digest.FromBytes(blob); dgst != layer.Digest {
+		return fmt.Errorf("Blob digest '%s' does not match descriptor digest '%s'", dgst, layer.Digest)
+	}
+
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, _ := mr.repo(true)
+	repo.blobs[layer.Digest] = append([]byte{}, blob...)
+	return nil
+}
+
+// PutManifestBytes stores a manifest or index under ref, a tag or digest.
+// Like a registry, every blob a manifest references must already be present,
+// an index may reference manifests that are not.
+func (mr *MemRepo) PutManifestBytes(ref, mediaType string, content []byte) (digest.Digest, error) {
+	var doc struct {
+		Config *ispec.Descriptor  `json:"config"`
+		Layers []ispec.Descriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return "", fmt.Errorf("Failed to PUT manifest, invalid content: %s", err)
+	}
+
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, _ := mr.repo(true)
+
+	blobs := doc.Layers
+	if doc.Config != nil {
+		blobs = append(blobs, *doc.Config)
+	}
+	for _, blob := range blobs {
+		if _, ok := repo.blobs[blob.Digest]; !ok {
+			return "", fmt.Errorf("Failed to PUT manifest, referenced blob '%s' %w", blob.Digest, ErrNotFound)
+		}
+	}
+
+	dgst := digest.FromBytes(content)
+	if refDigest, err := digest.Parse(ref); err == nil {
+		if refDigest != dgst {
+			return "", fmt.Errorf("Failed to PUT manifest, digest '%s' does not match reference '%s'", dgst, ref)
+		}
+	} else {
+		repo.tags[ref] = dgst
+	}
+
+	repo.manifests[dgst] = memManifest{mediaType: mediaType, content: append([]byte{}, content...)}
+	return dgst, nil
+}
+
+// DeleteManifest removes tag ref, or the manifest at digest ref along with
+// every tag pointing at it.
+func (mr *MemRepo) DeleteManifest(ref string) error {
+	mr.store.lock.Lock()
+	defer mr.store.lock.Unlock()
+
+	repo, err := mr.repo(false)
+	if err != nil {
+		return err
+	}
+
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		if _, ok := repo.tags[ref]; !ok {
+			return fmt.Errorf("Tag '%s' %w", ref, ErrNotFound)
+		}
+		delete(repo.tags, ref)
+		return nil
+	}
+
+	if _, ok := repo.manifests[dgst]; !ok {
+		return fmt.Errorf("Manifest '%s' %w", dgst, ErrNotFound)
+	}
+	delete(repo.manifests, dgst)
+	for tag, tagged := range repo.tags {
+		if tagged == dgst {
+			delete(repo.tags, tag)
+		}
+	}
+	return nil
+}
+
+func (mr *MemRepo) PutManifest(manifest *ispec.Manifest) (digest.Digest, error) {
+	log.WithFields(log.Fields{
+		"manifest": manifest,
+	}).Debug("Mem.PutManifest() called")
+
+	ref, mediaType, content, err := marshalManifest(manifest, mr.RepoTag())
+	if err != nil {
+		return "", err
+	}
+	return mr.PutManifestBytes(ref, mediaType, content)
+}
+
+func (mr *MemRepo) PutArtifact(artifactName, artifactType string, artifactBlob []byte) error {
+	return putArtifact(mr, artifactName, artifactType, artifactBlob)
+}
@@ -0,0 +1,150 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestMemNotFound(t *testing.T) {
+	ociApi := apitest.NewMemRepo(t, "test/img:v1")
+
+	if _, _, err := ociApi.GetManifestBytes("v1"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Manifest of unknown repository: expected ErrNotFound, got %v", err)
+	}
+
+	apitest.PushImage(t, ociApi)
+
+	if _, _, err := ociApi.GetManifestBytes("v2"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Manifest of unknown tag: expected ErrNotFound, got %v", err)
+	}
+	if _, _, err := ociApi.GetManifestBytes(digest.FromString("none").String()); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Manifest of unknown digest: expected ErrNotFound, got %v", err)
+	}
+	missing := ispec.Descriptor{Digest: digest.FromString("none")}
+	if _, err := ociApi.GetBlob(&missing); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Unknown blob: expected ErrNotFound, got %v", err)
+	}
+	if err := ociApi.BlobHead(&missing); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("HEAD of unknown blob: expected ErrNotFound, got %v", err)
+	}
+	if err := ociApi.DeleteManifest("v2"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Delete of unknown tag: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemPutManifestByDigest(t *testing.T) {
+	ociApi := apitest.NewMemRepo(t, "test/img:v1")
+	content, err := json.Marshal(apitest.Manifest(t, ociApi))
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %s", err)
+	}
+	dgst := digest.FromBytes(content)
+
+	if _, err := ociApi.PutManifestBytes(digest.FromString("other").String(), ispec.MediaTypeImageManifest, content); err == nil {
+		t.Errorf("PUT under another digest succeeded")
+	}
+
+	putDigest, err := ociApi.PutManifestBytes(dgst.String(), ispec.MediaTypeImageManifest, content)
+	if err != nil {
+		t.Fatalf("Failed to put manifest by digest: %s", err)
+	}
+	if putDigest != dgst {
+		t.Errorf("PUT returned digest %s, expected %s", putDigest, dgst)
+	}
+
+	mediaType, got, err := ociApi.GetManifestBytes(dgst.String())
+	if err != nil {
+		t.Fatalf("Failed to get manifest by digest: %s", err)
+	}
+	if mediaType != ispec.MediaTypeImageManifest || string(got) != string(content) {
+		t.Errorf("Got %s %q, expected the manifest put", mediaType, got)
+	}
+
+	// a digest is not a tag
+	tags, err := ociApi.GetRepoTags()
+	if err != nil {
+		t.Fatalf("Failed to list tags: %s", err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("PUT by digest created tags %v", tags)
+	}
+}
+
+func TestMemPutManifestMissingBlob(t *testing.T) {
+	ociApi := apitest.NewMemRepo(t, "test/img:v1")
+	manifest := ispec.Manifest{
+		Versioned: api.ManifestV2,
+		MediaType: ispec.MediaTypeImageManifest,
+		Config:    ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: digest.FromString("{}"), Size: 2},
+	}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %s", err)
+	}
+
+	if _, err := ociApi.PutManifestBytes("v1", ispec.MediaTypeImageManifest, content); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("PUT of manifest with a missing config: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemSubjectReferrers(t *testing.T) {
+	ociApi := apitest.NewMemRepo(t, "test/img:v1")
+	subject := apitest.PushImage(t, ociApi)
+
+	if err := ociApi.PutArtifact("sig", "application/vnd.example.sig", []byte("signature")); err != nil {
+		t.Fatalf("Failed to put artifact: %s", err)
+	}
+
+	// the artifact refers to the tag's manifest without moving the tag
+	_, tagged, err := ociApi.GetManifestBytes("v1")
+	if err != nil {
+		t.Fatalf("Failed to get tag: %s", err)
+	}
+	if digest.FromBytes(tagged) != subject.Digest {
+		t.Errorf("Artifact moved tag v1 to %s", digest.FromBytes(tagged))
+	}
+
+	referrers, err := ociApi.GetReferrers(&subject)
+	if err != nil {
+		t.Fatalf("Failed to get referrers: %s", err)
+	}
+	if len(referrers.Manifests) != 1 {
+		t.Fatalf("Got %d referrers, expected 1", len(referrers.Manifests))
+	}
+	ref := referrers.Manifests[0]
+	if ref.ArtifactType != "application/vnd.example.sig" {
+		t.Errorf("Referrer has artifactType %q", ref.ArtifactType)
+	}
+
+	_, refContent, err := ociApi.GetManifestBytes(ref.Digest.String())
+	if err != nil {
+		t.Fatalf("Failed to get referrer: %s", err)
+	}
+	var artifact ispec.Manifest
+	if err := json.Unmarshal(refContent, &artifact); err != nil {
+		t.Fatalf("Failed to parse referrer: %s", err)
+	}
+	if artifact.Subject == nil || artifact.Subject.Digest != subject.Digest {
+		t.Errorf("Referrer has subject %v, expected %s", artifact.Subject, subject.Digest)
+	}
+	if len(artifact.Layers) != 1 || artifact.Layers[0].Annotations[ispec.AnnotationTitle] != "sig" {
+		t.Errorf("Referrer has layers %v, expected one titled sig", artifact.Layers)
+	}
+
+	// an unknown subject has no referrers rather than an error
+	other := ispec.Descriptor{Digest: digest.FromString("none")}
+	referrers, err = ociApi.GetReferrers(&other)
+	if err != nil {
+		t.Fatalf("Failed to get referrers of unknown subject: %s", err)
+	}
+	if len(referrers.Manifests) != 0 {
+		t.Errorf("Unknown subject has referrers %v", referrers.Manifests)
+	}
+}
@@ -2,20 +2,25 @@ package api
 
 // dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/opencontainers/umoci"
+	"github.com/opencontainers/umoci/oci/casext"
 )
 
 type OCIDirRepo struct {
@@ -141,7 +146,7 @@ func (odr *OCIDirRepo) GetManifest() (*ispec.Manifest, []byte, error) {
 
 	blob, err := oci.FromDescriptor(context.Background(), descriptorPaths[0].Descriptor())
 	if err != nil {
-		return &ispec.Manifest{}, []byte{}, fmt.Errorf("Failed to parse referenced blob for descriptor '%s' for OCI tag '%s' in OCI Layout at directory %q: %s", descriptorPaths[0].Descriptor(), tag, ociDir, err)
+		return &ispec.Manifest{}, []byte{}, fmt.Errorf("Failed to parse referenced blob for descriptor '%v' for OCI tag '%s' in OCI Layout at directory %q: %s", descriptorPaths[0].Descriptor(), tag, ociDir, err)
 	}
 
 	defer blob.Close()
@@ -229,6 +234,7 @@ func (odr *OCIDirRepo) GetReferrers(image *ispec.Descriptor) (*ispec.Index, erro
 					MediaType:    indexManifest.MediaType,
 					Digest:       indexManifest.Digest,
 					Size:         indexManifest.Size,
+					Annotations:  refManifest.Annotations,
 				}
 				refs.Manifests = append(refs.Manifests, match)
 			}
@@ -257,22 +263,267 @@ func (odr *OCIDirRepo) GetBlob(layer *ispec.Descriptor) ([]byte, error) {
 	return blobBytes, nil
 }
 
+func (odr *OCIDirRepo) GetRepositories() ([]string, error) {
+	return []string{odr.OCIDir()}, nil
+}
+
+// refName maps a tag, or the URL's own tag if empty, to a reference name in
+// the layout index: oci:///ocidir:img:v2.31 + "v2.32" -> "img:v2.32"
+func (odr *OCIDirRepo) refName(tag string) string {
+	image := odr.ImageName()
+	if tag == "" {
+		tag = odr.RepoTag()
+	}
+
+	switch {
+	case image == "":
+		return tag
+	case tag == "":
+		return image
+	}
+	return fmt.Sprintf("%s:%s", image, tag)
+}
+
+// GetManifestBytes returns the media type and content of the manifest at
+// ref, a digest or a tag of this image.
+func (odr *OCIDirRepo) GetManifestBytes(ref string) (string, []byte, error) {
+	ociDir := odr.OCIDir()
+	oci, err := umoci.OpenLayout(ociDir)
+	if err != nil {
+		return "", []byte{}, fmt.Errorf("Failed to open OCI Layout at directory %q: %s", ociDir, err)
+	}
+	defer oci.Close()
+
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		ociIndex, err := oci.GetIndex(context.Background())
+		if err != nil {
+			return "", []byte{}, fmt.Errorf("Failed to get index from OCI Layout at directory %q: %s", ociDir, err)
+		}
+
+		// like umoci.UpdateReference, the last entry for a name wins
+		dgst = ""
+		name := odr.refName(ref)
+		for _, desc := range ociIndex.Manifests {
+			if desc.Annotations[ispec.AnnotationRefName] == name {
+				dgst = desc.Digest
+			}
+		}
+		if dgst == "" {
+			return "", []byte{}, fmt.Errorf("Reference '%s' in OCI Layout at directory %q %w", name, ociDir, ErrNotFound)
+		}
+	}
+
+	manifestBytes, err := odr.GetBlob(&ispec.Descriptor{Digest: dgst})
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	mediaType, err := DetectManifestMediaType(manifestBytes)
+	if err != nil {
+		return "", []byte{}, fmt.Errorf("Failed to parse manifest '%s': %s", dgst, err)
+	}
+	return mediaType, manifestBytes, nil
+}
+
 func (odr *OCIDirRepo) BlobHead(layer *ispec.Descriptor) error {
-	return fmt.Errorf("Not implemented yet")
+	algo, hash, ok := strings.Cut(layer.Digest.String(), ":")
+	if !ok {
+		return fmt.Errorf("Failed to split layer digest '%s' into algo and hash", layer.Digest)
+	}
+
+	blobPath := filepath.Join(odr.OCIDir(), "blobs", algo, hash)
+	if _, err := os.Stat(blobPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Blob '%s' %w", layer.Digest, ErrNotFound)
+		}
+		return err
+	}
+	return nil
 }
 
-func (odr *OCIDirRepo) GetRepositories() ([]string, error) {
-	return []string{odr.OCIDir()}, nil
+// ociDirCreateLock keeps concurrent writers from racing to create a layout
+var ociDirCreateLock sync.Mutex
+
+// openOrCreate opens the layout, creating it if the directory does not exist
+func (odr *OCIDirRepo) openOrCreate() (casext.Engine, error) {
+	ociDir := odr.OCIDir()
+
+	ociDirCreateLock.Lock()
+	if _, err := os.Stat(ociDir); os.IsNotExist(err) {
+		oci, err := umoci.CreateLayout(ociDir)
+		ociDirCreateLock.Unlock()
+		if err != nil {
+			return casext.Engine{}, fmt.Errorf("Failed to create OCI Layout at directory %q: %s", ociDir, err)
+		}
+		return oci, nil
+	}
+	ociDirCreateLock.Unlock()
+
+	oci, err := umoci.OpenLayout(ociDir)
+	if err != nil {
+		return casext.Engine{}, fmt.Errorf("Failed to open OCI Layout at directory %q: %s", ociDir, err)
+	}
+	return oci, nil
 }
 
 func (odr *OCIDirRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
-	return fmt.Errorf("Not implemented yet")
+	log.WithFields(log.Fields{
+		"layer":    layer,
+		"blobSize": len(blob),
+	}).Debug("OCIDir.PutBlob() called")
+
+	if err := odr.BlobHead(layer); err == nil {
+		return nil
+	}
+
+	oci, err := odr.openOrCreate()
+	if err != nil {
+		return err
+	}
+	defer oci.Close()
+
+	return odr.putBlob(oci, layer, bytes.NewReader(blob))
 }
 
-func (odr *OCIDirRepo) PutManifest(manifest *ispec.Manifest) error {
-	return fmt.Errorf("Not implemented yet")
+// PutBlobReader writes the blob for layer as it is read from reader
+func (odr *OCIDirRepo) PutBlobReader(layer *ispec.Descriptor, reader io.Reader) error {
+	if err := odr.BlobHead(layer); err == nil {
+		return nil
+	}
+
+	oci, err := odr.openOrCreate()
+	if err != nil {
+		return err
+	}
+	defer oci.Close()
+
+	return odr.putBlob(oci, layer, reader)
+}
+
+func (odr *OCIDirRepo) putBlob(oci casext.Engine, layer *ispec.Descriptor, reader io.Reader) error {
+	dgst, _, err := oci.PutBlob(context.Background(), reader)
+	if err != nil {
+		return fmt.Errorf("Failed to write blob to OCI Layout at directory %q: %s", odr.OCIDir(), err)
+	}
+
+	if dgst != layer.Digest {
+		oci.DeleteBlob(context.Background(), dgst)
+		return fmt.Errorf("Blob digest '%s' does not match descriptor digest '%s'", dgst, layer.Digest)
+	}
+	return nil
+}
+
+// PutManifestBytes stores a manifest and records it in the layout index,
+// under the reference name for tag ref, or untagged if ref is a digest so
+// that GetReferrers can still find it.
+func (odr *OCIDirRepo) PutManifestBytes(ref, mediaType string, content []byte) (digest.Digest, error) {
+	log.WithFields(log.Fields{
+		"ref":       ref,
+		"mediaType": mediaType,
+	}).Debug("OCIDir.PutManifestBytes() called")
+
+	dgst := digest.FromBytes(content)
+	refDigest, refErr := digest.Parse(ref)
+	if refErr == nil && refDigest != dgst {
+		return "", fmt.Errorf("Failed to PUT manifest, digest '%s' does not match reference '%s'", dgst, ref)
+	}
+
+	desc := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(content)),
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err == nil {
+		desc.ArtifactType = manifest.ArtifactType
+	}
+
+	if err := odr.PutBlob(&desc, content); err != nil {
+		return "", err
+	}
+
+	oci, err := odr.openOrCreate()
+	if err != nil {
+		return "", err
+	}
+	defer oci.Close()
+
+	if refErr != nil {
+		name := odr.refName(ref)
+		if err := oci.UpdateReference(context.Background(), name, desc); err != nil {
+			return "", fmt.Errorf("Failed to update reference '%s' in OCI Layout at directory %q: %s", name, odr.OCIDir(), err)
+		}
+		return dgst, nil
+	}
+
+	ociIndex, err := oci.GetIndex(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("Failed to get index from OCI Layout at directory %q: %s", odr.OCIDir(), err)
+	}
+	for _, existing := range ociIndex.Manifests {
+		if existing.Digest == dgst {
+			return dgst, nil
+		}
+	}
+	ociIndex.Manifests = append(ociIndex.Manifests, desc)
+	if err := oci.PutIndex(context.Background(), ociIndex); err != nil {
+		return "", fmt.Errorf("Failed to put index to OCI Layout at directory %q: %s", odr.OCIDir(), err)
+	}
+	return dgst, nil
+}
+
+// DeleteManifest removes the reference name for tag ref, or every index
+// entry for digest ref.  Blobs are left in place.
+func (odr *OCIDirRepo) DeleteManifest(ref string) error {
+	log.WithFields(log.Fields{
+		"ref": ref,
+	}).Debug("OCIDir.DeleteManifest() called")
+
+	ociDir := odr.OCIDir()
+	oci, err := umoci.OpenLayout(ociDir)
+	if err != nil {
+		return fmt.Errorf("Failed to open OCI Layout at directory %q: %s", ociDir, err)
+	}
+	defer oci.Close()
+
+	ociIndex, err := oci.GetIndex(context.Background())
+	if err != nil {
+		return fmt.Errorf("Failed to get index from OCI Layout at directory %q: %s", ociDir, err)
+	}
+
+	dgst, dgstErr := digest.Parse(ref)
+	name := odr.refName(ref)
+	manifests := []ispec.Descriptor{}
+	for _, desc := range ociIndex.Manifests {
+		if dgstErr == nil && desc.Digest == dgst {
+			continue
+		}
+		if dgstErr != nil && desc.Annotations[ispec.AnnotationRefName] == name {
+			continue
+		}
+		manifests = append(manifests, desc)
+	}
+	if len(manifests) == len(ociIndex.Manifests) {
+		return fmt.Errorf("Reference '%s' in OCI Layout at directory %q %w", ref, ociDir, ErrNotFound)
+	}
+
+	ociIndex.Manifests = manifests
+	if err := oci.PutIndex(context.Background(), ociIndex); err != nil {
+		return fmt.Errorf("Failed to put index to OCI Layout at directory %q: %s", ociDir, err)
+	}
+	return nil
+}
+
+func (odr *OCIDirRepo) PutManifest(manifest *ispec.Manifest) (digest.Digest, error) {
+	ref, mediaType, content, err := marshalManifest(manifest, odr.RepoTag())
+	if err != nil {
+		return "", err
+	}
+	return odr.PutManifestBytes(ref, mediaType, content)
 }
 
-func (odr *OCIDirRepo) PutArtifact(aritfactName, artifactType string, artifactBlob []byte) error {
-	return fmt.Errorf("Not implemented yet")
+func (odr *OCIDirRepo) PutArtifact(artifactName, artifactType string, artifactBlob []byte) error {
+	return putArtifact(odr, artifactName, artifactType, artifactBlob)
 }
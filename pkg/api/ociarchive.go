@@ -0,0 +1,522 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ociIndexFile = "index.json"
+	ociBlobsDir  = "blobs"
+)
+
+// OCIArchiveRepo is an OCI layout stored in a single uncompressed tar file,
+// the same format as the containers/image oci-archive transport.  Members
+// are read in place, writes append blobs and a new index.json to the tar.
+type OCIArchiveRepo struct {
+	url    *url.URL
+	config *OCIAPIConfig
+
+	// the index of the archive, kept until the archive changes
+	lock  sync.Mutex
+	index *tarIndex
+}
+
+func NewOCIArchiveRepo(url *url.URL, config *OCIAPIConfig) (*OCIArchiveRepo, error) {
+	return &OCIArchiveRepo{url: url, config: config}, nil
+}
+
+func (oar *OCIArchiveRepo) Type() OCIRepoType {
+	return OCIArchiveRepoType
+}
+
+// oci-archive:///home/ubuntu/images/myimage.tar:v2.1
+//
+//	archive = host + path - (:ref)
+//	ref = the org.opencontainers.image.ref.name in the archived index.json
+func (oar *OCIArchiveRepo) ArchivePath() string {
+	archive, _, _ := strings.Cut(oar.url.Path, ":")
+	return filepath.Join(oar.url.Host, archive)
+}
+
+func (oar *OCIArchiveRepo) RepoPath() string {
+	return oar.ArchivePath()
+}
+
+func (oar *OCIArchiveRepo) RepoTag() string {
+	_, ref, _ := strings.Cut(oar.url.Path, ":")
+	return ref
+}
+
+func (oar *OCIArchiveRepo) ImageName() string {
+	return strings.TrimSuffix(filepath.Base(oar.ArchivePath()), ".tar")
+}
+
+func (oar *OCIArchiveRepo) SourceURL() string {
+	return oar.url.String()
+}
+
+func (oar *OCIArchiveRepo) open() (*tarIndex, error) {
+	oar.lock.Lock()
+	defer oar.lock.Unlock()
+
+	ti, err := openTarIndex(oar.ArchivePath(), oar.index)
+	if err != nil {
+		return nil, err
+	}
+	oar.index = ti
+	return ti, nil
+}
+
+// update appends the files fn returns for the current index of the
+// archive, nil if there is no archive yet.  The lock is held from reading the
+// index through the append, so concurrent writes each see the one before.
+func (oar *OCIArchiveRepo) update(fn func(ti *tarIndex) ([]tarFile, error)) error {
+	oar.lock.Lock()
+	defer oar.lock.Unlock()
+
+	var ti *tarIndex
+	if _, err := os.Stat(oar.ArchivePath()); err == nil {
+		if ti, err = openTarIndex(oar.ArchivePath(), oar.index); err != nil {
+			return err
+		}
+		oar.index = ti
+	}
+
+	files, err := fn(ti)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	if ti, err = appendTarFiles(oar.ArchivePath(), oar.index, files); err != nil {
+		return err
+	}
+	oar.index = ti
+	return nil
+}
+
+func (oar *OCIArchiveRepo) getIndex(ti *tarIndex) (*ispec.Index, error) {
+	indexBytes, err := ti.ReadFile(ociIndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var index ispec.Index
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal index from archive %q: %s", ti.path, err)
+	}
+	return &index, nil
+}
+
+func (oar *OCIArchiveRepo) GetRepoTagList() (*dspec.TagList, error) {
+	tagList := dspec.TagList{Tags: []string{}}
+
+	// if URI is pointing to an image, no RepoTags are represent
+	if len(oar.RepoTag()) > 0 {
+		return &tagList, nil
+	}
+
+	tagList.Name = oar.ImageName()
+	tags, err := oar.GetRepoTags()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get repo tags: %s", err)
+	}
+
+	tagList.Tags = tags
+	return &tagList, nil
+}
+
+func (oar *OCIArchiveRepo) GetRepoTags() ([]string, error) {
+	ti, err := oar.open()
+	if err != nil {
+		return []string{}, err
+	}
+
+	index, err := oar.getIndex(ti)
+	if err != nil {
+		return []string{}, err
+	}
+
+	tags := []string{}
+	for _, desc := range index.Manifests {
+		if ref, ok := desc.Annotations[ispec.AnnotationRefName]; ok {
+			tags = append(tags, ref)
+		}
+	}
+	return tags, nil
+}
+
+func (oar *OCIArchiveRepo) GetRepositories() ([]string, error) {
+	return []string{oar.ArchivePath()}, nil
+}
+
+// resolve returns the index descriptor for ref, or the only manifest in the
+// archive if ref is empty.
+func (oar *OCIArchiveRepo) resolve(index *ispec.Index, ref string) (*ispec.Descriptor, error) {
+	if ref == "" {
+		if len(index.Manifests) != 1 {
+			return nil, fmt.Errorf("Archive %q contains %d manifests, a reference is required", oar.ArchivePath(), len(index.Manifests))
+		}
+		return &index.Manifests[0], nil
+	}
+
+	var found *ispec.Descriptor
+	for i, desc := range index.Manifests {
+		if desc.Annotations[ispec.AnnotationRefName] == ref {
+			found = &index.Manifests[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Reference '%s' in archive %q %w", ref, oar.ArchivePath(), ErrNotFound)
+	}
+	return found, nil
+}
+
+// GetManifestBytes returns the media type and content of the manifest at
+// ref, a digest or a reference name in the archived index.
+func (oar *OCIArchiveRepo) GetManifestBytes(ref string) (string, []byte, error) {
+	ti, err := oar.open()
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		index, err := oar.getIndex(ti)
+		if err != nil {
+			return "", []byte{}, err
+		}
+		desc, err := oar.resolve(index, ref)
+		if err != nil {
+			return "", []byte{}, err
+		}
+		dgst = desc.Digest
+	}
+
+	manifestBytes, err := ti.ReadFile(blobPath(dgst))
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	mediaType, err := DetectManifestMediaType(manifestBytes)
+	if err != nil {
+		return "", []byte{}, fmt.Errorf("Failed to parse manifest '%s': %s", dgst, err)
+	}
+	return mediaType, manifestBytes, nil
+}
+
+func (oar *OCIArchiveRepo) GetManifest() (*ispec.Manifest, []byte, error) {
+	ti, err := oar.open()
+	if err != nil {
+		return &ispec.Manifest{}, []byte{}, err
+	}
+
+	index, err := oar.getIndex(ti)
+	if err != nil {
+		return &ispec.Manifest{}, []byte{}, err
+	}
+
+	ref := oar.RepoTag()
+	desc, err := oar.resolve(index, ref)
+	if err != nil {
+		return &ispec.Manifest{}, []byte{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"archive": ti.path,
+		"ref":     ref,
+		"digest":  desc.Digest,
+	}).Debug("OCIArchive.GetManifest resolved reference")
+
+	if desc.MediaType != ispec.MediaTypeImageManifest {
+		return &ispec.Manifest{}, []byte{}, fmt.Errorf("Descriptor does not point to a manifest: '%s' for OCI tag '%s'", desc.MediaType, ref)
+	}
+
+	manifestBytes, err := ti.ReadFile(blobPath(desc.Digest))
+	if err != nil {
+		return &ispec.Manifest{}, []byte{}, fmt.Errorf("Failed to read OCI Manifest blob '%s' for OCI tag '%s': %s", desc.Digest, ref, err)
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return &ispec.Manifest{}, []byte{}, fmt.Errorf("Failed to unmarshal OCI Manifest blob '%s' for OCI tag '%s': %s", desc.Digest, ref, err)
+	}
+	return &manifest, manifestBytes, nil
+}
+
+func (oar *OCIArchiveRepo) GetImage(config *ispec.Descriptor) (*ispec.Image, error) {
+	if config.MediaType != ispec.MediaTypeImageConfig {
+		return &ispec.Image{}, fmt.Errorf("bad image config type: %s", config.MediaType)
+	}
+
+	configBytes, err := oar.GetBlob(config)
+	if err != nil {
+		return &ispec.Image{}, err
+	}
+
+	var img ispec.Image
+	if err := json.Unmarshal(configBytes, &img); err != nil {
+		return &ispec.Image{}, fmt.Errorf("Failed to unmarshal image config: %s", err)
+	}
+	return &img, nil
+}
+
+func (oar *OCIArchiveRepo) GetReferrers(image *ispec.Descriptor) (*ispec.Index, error) {
+	ti, err := oar.open()
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := oar.getIndex(ti)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := ispec.Index{
+		MediaType: ispec.MediaTypeImageIndex,
+	}
+	for _, indexManifest := range index.Manifests {
+		if indexManifest.MediaType != ispec.MediaTypeImageManifest || indexManifest.Digest == image.Digest {
+			continue
+		}
+
+		blob, err := ti.ReadFile(blobPath(indexManifest.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read index manifest blob: %s", err)
+		}
+
+		var refManifest ispec.Manifest
+		if err := json.Unmarshal(blob, &refManifest); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal index manifest blob into manifest: %s", err)
+		}
+
+		if refManifest.Subject != nil && refManifest.Subject.Digest == image.Digest {
+			refs.Manifests = append(refs.Manifests, ispec.Descriptor{
+				ArtifactType: refManifest.ArtifactType,
+				MediaType:    indexManifest.MediaType,
+				Digest:       indexManifest.Digest,
+				Size:         indexManifest.Size,
+				Annotations:  refManifest.Annotations,
+			})
+		}
+	}
+
+	return &refs, nil
+}
+
+func blobPath(dgst digest.Digest) string {
+	return path.Join(ociBlobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (oar *OCIArchiveRepo) GetBlob(layer *ispec.Descriptor) ([]byte, error) {
+	if err := layer.Digest.Validate(); err != nil {
+		return []byte{}, fmt.Errorf("Invalid blob digest '%s': %s", layer.Digest, err)
+	}
+
+	ti, err := oar.open()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return ti.ReadFile(blobPath(layer.Digest))
+}
+
+func (oar *OCIArchiveRepo) BlobHead(layer *ispec.Descriptor) error {
+	ti, err := oar.open()
+	if err != nil {
+		return err
+	}
+
+	if !ti.Has(blobPath(layer.Digest)) {
+		return fmt.Errorf("Blob '%s' in archive %q %w", layer.Digest, ti.path, ErrNotFound)
+	}
+	return nil
+}
+
+func (oar *OCIArchiveRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
+	log.WithFields(log.Fields{
+		"layer":    layer,
+		"blobSize": len(blob),
+	}).Debug("OCIArchive.PutBlob() called")
+
+	if dgst := digest.FromBytes(blob); dgst != layer.Digest {
+		return fmt.Errorf("Blob digest '%s' does not match descriptor digest '%s'", dgst, layer.Digest)
+	}
+
+	err := oar.update(func(ti *tarIndex) ([]tarFile, error) {
+		files := []tarFile{{name: blobPath(layer.Digest), content: blob}}
+		if ti == nil {
+			return append(newArchiveFiles(), files...), nil
+		}
+		// blobs are content addressed, no need to write one twice
+		if ti.Has(blobPath(layer.Digest)) {
+			return nil, nil
+		}
+		return files, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to put blob: %s", err)
+	}
+	return nil
+}
+
+// newArchiveFiles are the layout files written when creating an archive.
+func newArchiveFiles() []tarFile {
+	layout, _ := json.Marshal(ispec.ImageLayout{Version: ispec.ImageLayoutVersion})
+	index, _ := json.Marshal(ispec.Index{Versioned: ManifestV2, MediaType: ispec.MediaTypeImageIndex, Manifests: []ispec.Descriptor{}})
+	return []tarFile{
+		{name: ispec.ImageLayoutFile, content: layout},
+		{name: ociIndexFile, content: index},
+	}
+}
+
+// PutManifestBytes appends a manifest and an updated index.json to the
+// archive.  A tag ref replaces whatever held the tag, a digest ref is added
+// untagged so it can still be found as a referrer.
+func (oar *OCIArchiveRepo) PutManifestBytes(ref, mediaType string, content []byte) (digest.Digest, error) {
+	log.WithFields(log.Fields{
+		"ref":       ref,
+		"mediaType": mediaType,
+	}).Debug("OCIArchive.PutManifestBytes() called")
+
+	var doc struct {
+		ArtifactType string             `json:"artifactType"`
+		Config       *ispec.Descriptor  `json:"config"`
+		Layers       []ispec.Descriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return "", fmt.Errorf("Failed to PUT manifest, invalid content: %s", err)
+	}
+
+	dgst := digest.FromBytes(content)
+	refDigest, refErr := digest.Parse(ref)
+	if refErr == nil && refDigest != dgst {
+		return "", fmt.Errorf("Failed to PUT manifest, digest '%s' does not match reference '%s'", dgst, ref)
+	}
+
+	err := oar.update(func(ti *tarIndex) ([]tarFile, error) {
+		if ti == nil {
+			return nil, fmt.Errorf("archive %q %w", oar.ArchivePath(), ErrNotFound)
+		}
+
+		blobs := doc.Layers
+		if doc.Config != nil {
+			blobs = append(blobs, *doc.Config)
+		}
+		for _, blob := range blobs {
+			if !ti.Has(blobPath(blob.Digest)) {
+				return nil, fmt.Errorf("referenced blob '%s' %w", blob.Digest, ErrNotFound)
+			}
+		}
+
+		index, err := oar.getIndex(ti)
+		if err != nil {
+			return nil, err
+		}
+
+		desc := ispec.Descriptor{
+			MediaType:    mediaType,
+			ArtifactType: doc.ArtifactType,
+			Digest:       dgst,
+			Size:         int64(len(content)),
+		}
+		if refErr != nil {
+			desc.Annotations = map[string]string{ispec.AnnotationRefName: ref}
+		}
+
+		manifests := []ispec.Descriptor{}
+		for _, existing := range index.Manifests {
+			existingRef := existing.Annotations[ispec.AnnotationRefName]
+			if existing.Digest == dgst && existingRef == "" {
+				continue
+			}
+			if refErr != nil && existingRef == ref {
+				continue
+			}
+			manifests = append(manifests, existing)
+		}
+		index.Manifests = append(manifests, desc)
+
+		indexJSON, err := json.Marshal(index)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal index: %s", err)
+		}
+
+		files := []tarFile{{name: ociIndexFile, content: indexJSON}}
+		if !ti.Has(blobPath(dgst)) {
+			files = append([]tarFile{{name: blobPath(dgst), content: content}}, files...)
+		}
+		return files, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to PUT manifest, %w", err)
+	}
+	return dgst, nil
+}
+
+// DeleteManifest appends an index.json without tag ref, or without any
+// entry for digest ref.  The archive only grows, nothing is removed from it.
+func (oar *OCIArchiveRepo) DeleteManifest(ref string) error {
+	log.WithFields(log.Fields{
+		"ref": ref,
+	}).Debug("OCIArchive.DeleteManifest() called")
+
+	dgst, dgstErr := digest.Parse(ref)
+	err := oar.update(func(ti *tarIndex) ([]tarFile, error) {
+		if ti == nil {
+			return nil, fmt.Errorf("Reference '%s' in archive %q %w", ref, oar.ArchivePath(), ErrNotFound)
+		}
+		index, err := oar.getIndex(ti)
+		if err != nil {
+			return nil, err
+		}
+
+		manifests := []ispec.Descriptor{}
+		for _, desc := range index.Manifests {
+			if dgstErr == nil && desc.Digest == dgst {
+				continue
+			}
+			if dgstErr != nil && desc.Annotations[ispec.AnnotationRefName] == ref {
+				continue
+			}
+			manifests = append(manifests, desc)
+		}
+		if len(manifests) == len(index.Manifests) {
+			return nil, fmt.Errorf("Reference '%s' in archive %q %w", ref, ti.path, ErrNotFound)
+		}
+		index.Manifests = manifests
+
+		indexJSON, err := json.Marshal(index)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal index: %s", err)
+		}
+		return []tarFile{{name: ociIndexFile, content: indexJSON}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to DELETE manifest: %w", err)
+	}
+	return nil
+}
+
+func (oar *OCIArchiveRepo) PutManifest(manifest *ispec.Manifest) (digest.Digest, error) {
+	ref, mediaType, content, err := marshalManifest(manifest, oar.RepoTag())
+	if err != nil {
+		return "", err
+	}
+	return oar.PutManifestBytes(ref, mediaType, content)
+}
+
+func (oar *OCIArchiveRepo) PutArtifact(artifactName, artifactType string, artifactBlob []byte) error {
+	return putArtifact(oar, artifactName, artifactType, artifactBlob)
+}
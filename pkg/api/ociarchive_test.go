@@ -0,0 +1,67 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOCIArchiveConcurrentWrites(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "images.tar")
+	ociApi, err := api.NewOCIAPI("oci-archive://"+archive+":v1", nil)
+	if err != nil {
+		t.Fatalf("Failed to create archive repo: %s", err)
+	}
+	manifest := apitest.Manifest(t, ociApi)
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %s", err)
+	}
+
+	// every writer reads the index the one before appended
+	group := sync.WaitGroup{}
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		i := i
+		group.Add(2)
+		go func() {
+			defer group.Done()
+			blob := []byte(fmt.Sprintf("blob %d", i))
+			errs <- ociApi.PutBlob(&ispec.Descriptor{Digest: digest.FromBytes(blob), Size: int64(len(blob))}, blob)
+		}()
+		go func() {
+			defer group.Done()
+			_, err := ociApi.PutManifestBytes(fmt.Sprintf("v%d", i), ispec.MediaTypeImageManifest, content)
+			errs <- err
+		}()
+	}
+	group.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Concurrent write failed: %s", err)
+		}
+	}
+
+	tags, err := ociApi.GetRepoTags()
+	if err != nil {
+		t.Fatalf("Failed to list tags: %s", err)
+	}
+	if len(tags) != 10 {
+		t.Errorf("Archive has tags %v, expected v0 to v9", tags)
+	}
+	for i := 0; i < 10; i++ {
+		blob := []byte(fmt.Sprintf("blob %d", i))
+		if err := ociApi.BlobHead(&ispec.Descriptor{Digest: digest.FromBytes(blob)}); err != nil {
+			t.Errorf("Blob %d is missing: %s", i, err)
+		}
+	}
+}
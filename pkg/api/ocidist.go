@@ -2,8 +2,11 @@ package api
 
 // dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -22,8 +25,24 @@ var ManifestV2 = specs.Versioned{
 
 const (
 	UserAgent = "ocidist/0.0.1 (https://github.com/project-machine/ocidist)"
+
+	// docker media types accepted when fetching manifests
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// docker media types of the config and layers of a docker manifest
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
 )
 
+var ManifestAcceptTypes = []string{
+	ispec.MediaTypeImageManifest,
+	ispec.MediaTypeImageIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}
+
 type OCIDistRepo struct {
 	url    *url.URL
 	config *OCIAPIConfig
@@ -51,6 +70,9 @@ func (odr *OCIDistRepo) BasePath() string {
 }
 
 func (odr *OCIDistRepo) RepoPath() string {
+	if repo, _, ok := strings.Cut(odr.url.Path, "@"); ok {
+		return strings.TrimLeft(repo, "/")
+	}
 	path := ""
 	toks := strings.Split(odr.url.Path, ":")
 	if len(toks) > 0 {
@@ -62,6 +84,10 @@ func (odr *OCIDistRepo) RepoPath() string {
 }
 
 func (odr *OCIDistRepo) RepoTag() string {
+	// repo@sha256:... names the manifest by digest
+	if _, dgst, ok := strings.Cut(odr.url.Path, "@"); ok {
+		return dgst
+	}
 	toks := strings.Split(odr.url.Path, ":")
 	if len(toks) > 0 {
 		return toks[len(toks)-1]
@@ -84,6 +110,7 @@ func (odr *OCIDistRepo) GetRepoTagList() (*dspec.TagList, error) {
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -94,6 +121,11 @@ func (odr *OCIDistRepo) GetRepoTagList() (*dspec.TagList, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("Failed to list tags of '%s', StatusCode: %d", repoPath, resp.StatusCode())
+	}
+
 	var tagList dspec.TagList
 	if err := json.Unmarshal([]byte(resp.Body()), &tagList); err != nil {
 		return nil, err
@@ -123,6 +155,7 @@ func (odr *OCIDistRepo) GetManifest() (*ispec.Manifest, []byte, error) {
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -155,6 +188,51 @@ func (odr *OCIDistRepo) GetManifest() (*ispec.Manifest, []byte, error) {
 	return &manifest, manifestBytes, nil
 }
 
+// GetManifestBytes fetches the manifest or index at ref, a tag or digest,
+// returning its media type and the exact bytes served by the registry.
+func (odr *OCIDistRepo) GetManifestBytes(ref string) (string, []byte, error) {
+	url := odr.BasePath()
+	repoPath := odr.RepoPath()
+
+	client, err := reggie.NewClient(url,
+		reggie.WithDebug(odr.config.Debug),
+		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
+		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
+	)
+	if err != nil {
+		return "", []byte{}, fmt.Errorf("Failed to create client for %s: %s", url, err)
+	}
+
+	req := client.NewRequest(
+		reggie.GET, "/v2/<name>/manifests/<reference>",
+		reggie.WithName(repoPath),
+		reggie.WithReference(ref)).
+		SetHeader("Accept", strings.Join(ManifestAcceptTypes, ", "))
+
+	log.WithFields(log.Fields{
+		"req.URL": req.URL,
+	}).Debug("OCIDist.GetManifestBytes() request URL")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", []byte{}, fmt.Errorf("Failed to get a response from server: %s", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return "", []byte{}, fmt.Errorf("Failed to get manifest '%s', StatusCode: %d", ref, resp.StatusCode())
+	}
+
+	manifestBytes := resp.Body()
+	if dgst, err := digest.Parse(ref); err == nil {
+		if err := dgst.Validate(); err != nil || digest.FromBytes(manifestBytes) != dgst {
+			return "", []byte{}, fmt.Errorf("Manifest content does not match digest '%s'", ref)
+		}
+	}
+
+	return resp.Header().Get("Content-Type"), manifestBytes, nil
+}
+
 func (odr *OCIDistRepo) ManifestHead() error {
 	url := odr.BasePath()
 	repoPath := odr.RepoPath()
@@ -163,6 +241,7 @@ func (odr *OCIDistRepo) ManifestHead() error {
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 		reggie.WithDefaultName(repoPath),
 	)
@@ -196,76 +275,104 @@ func (odr *OCIDistRepo) ManifestHead() error {
 	return nil
 }
 
-func (odr *OCIDistRepo) PutManifest(manifest *ispec.Manifest) error {
+func (odr *OCIDistRepo) PutManifest(manifest *ispec.Manifest) (digest.Digest, error) {
 	log.WithFields(log.Fields{
 		"manifest": manifest,
 	}).Debug("OCIDist.PutManifest() called")
 
-	manifestJSON, err := json.Marshal(manifest)
+	ref, mediaType, content, err := marshalManifest(manifest, odr.RepoTag())
 	if err != nil {
-		return fmt.Errorf("Failed to marshal manifest: %s", err)
+		return "", err
 	}
+	return odr.PutManifestBytes(ref, mediaType, content)
+}
 
+// PutManifestBytes uploads content as-is to ref, a tag or digest, and returns
+// the digest the registry stored it as.
+func (odr *OCIDistRepo) PutManifestBytes(ref, mediaType string, content []byte) (digest.Digest, error) {
 	url := odr.BasePath()
 	repoPath := odr.RepoPath()
-	tag := odr.RepoTag()
-	ref := tag
 
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithDefaultName(repoPath),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
-
-	// if manifest has a subject, then PUT via sha256
-	if manifest.Subject != nil {
-		dgst := digest.FromBytes(manifestJSON)
-		ref = dgst.String()
-		log.WithFields(log.Fields{
-			"digest": ref,
-		}).Debug("OCIDist.PutManifest() has subject, using PUT via digest")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create client for %s: %s", url, err)
 	}
 
 	log.WithFields(log.Fields{
-		"url":      url,
-		"repoPath": repoPath,
-		"ref":      ref,
-	}).Debug("OCIDist.PutManifest() created new client")
+		"url":       url,
+		"repoPath":  repoPath,
+		"ref":       ref,
+		"mediaType": mediaType,
+	}).Debug("OCIDist.PutManifestBytes() created new client")
 
 	req := client.NewRequest(
 		reggie.PUT, "/v2/<name>/manifests/<reference>",
 		reggie.WithReference(ref)).
-		SetHeader("Content-Type", ispec.MediaTypeImageManifest).
-		SetBody(manifestJSON)
+		SetHeader("Content-Type", mediaType).
+		SetBody(content)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Failed to PUT manifest: %s", err)
+		return "", fmt.Errorf("Failed to PUT manifest: %s", err)
+	}
+
+	if resp.StatusCode() != 201 {
+		return "", fmt.Errorf("Failed to PUT manifest '%s', StatusCode: %d", ref, resp.StatusCode())
+	}
+
+	// a registry that rewrote the manifest would break anything referencing
+	// the digest of content, e.g. signatures and referrers
+	dgst := digest.FromBytes(content)
+	if stored := resp.Header().Get("Docker-Content-Digest"); stored != "" && stored != dgst.String() {
+		return "", fmt.Errorf("Registry stored manifest '%s' as '%s' instead of '%s'", ref, stored, dgst)
+	}
+	return dgst, nil
+}
+
+// DeleteManifest removes ref, a tag or digest, from the repository.
+func (odr *OCIDistRepo) DeleteManifest(ref string) error {
+	url := odr.BasePath()
+	repoPath := odr.RepoPath()
+
+	client, err := reggie.NewClient(url,
+		reggie.WithDebug(odr.config.Debug),
+		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
+		reggie.WithDefaultName(repoPath),
+		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to create client for %s: %s", url, err)
 	}
 
 	log.WithFields(log.Fields{
-		"response":   resp,
-		"Status":     resp.Status(),
-		"StatusCode": resp.StatusCode(),
-	}).Debug("OCIDist.PutManifest() got response")
+		"url":      url,
+		"repoPath": repoPath,
+		"ref":      ref,
+	}).Debug("OCIDist.DeleteManifest() created new client")
 
-	if resp.StatusCode() != 201 {
-		return fmt.Errorf("Failed to PUT manifest, StatusCode: %d", resp.StatusCode())
-	}
-
-	/*
-		// update referres if defined on this manifest
-		if manifest.Subject != nil {
-			mSub := manifest.Subject
-			if mSub.MediaType != "" && mSub.Size > 0 && mSub.Digest.String() != "" {
-				if err := odr.PutReferrers(manifest); err != nil {
-					return fmt.Errorf("Failed PUT new referrers association with manifest: %s", err)
-				}
-			}
-		}
-	*/
-	return nil
+	req := client.NewRequest(
+		reggie.DELETE, "/v2/<name>/manifests/<reference>",
+		reggie.WithReference(ref))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to DELETE manifest: %s", err)
+	}
+
+	switch resp.StatusCode() {
+	case 202:
+		return nil
+	case 404:
+		return fmt.Errorf("Manifest '%s' %w", ref, ErrNotFound)
+	}
+	return fmt.Errorf("Failed to DELETE manifest '%s', StatusCode: %d", ref, resp.StatusCode())
 }
 
 func (odr *OCIDistRepo) GetManifestWithDigest() (*ispec.Manifest, []byte, digest.Digest, error) {
@@ -286,6 +393,7 @@ func (odr *OCIDistRepo) GetImage(image *ispec.Descriptor) (*ispec.Image, error)
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -313,6 +421,7 @@ func (odr *OCIDistRepo) GetReferrers(image *ispec.Descriptor) (*ispec.Index, err
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -346,6 +455,7 @@ func (odr *OCIDistRepo) GetBlob(layer *ispec.Descriptor) ([]byte, error) {
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -362,6 +472,43 @@ func (odr *OCIDistRepo) GetBlob(layer *ispec.Descriptor) ([]byte, error) {
 	return resp.Body(), nil
 }
 
+// GetBlobReader streams the blob for layer, the caller must Close() the
+// returned reader.
+func (odr *OCIDistRepo) GetBlobReader(layer *ispec.Descriptor) (io.ReadCloser, error) {
+	url := odr.BasePath()
+	repoPath := odr.RepoPath()
+
+	client, err := reggie.NewClient(url,
+		reggie.WithDebug(odr.config.Debug),
+		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
+		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create client for %s: %s", url, err)
+	}
+
+	req := client.NewRequest(
+		reggie.GET, "/v2/<name>/blobs/<digest>",
+		reggie.WithName(repoPath),
+		reggie.WithDigest(string(layer.Digest)))
+	req.SetDoNotParseResponse(true)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("Failed to get blob '%s', StatusCode: %d", layer.Digest, resp.StatusCode())
+	}
+
+	return resp.RawBody(), nil
+}
+
+// BlobHead checks the registry has layer, filling in the size it reports if
+// layer has none
 func (odr *OCIDistRepo) BlobHead(layer *ispec.Descriptor) error {
 	url := odr.BasePath()
 	repoPath := odr.RepoPath()
@@ -369,6 +516,7 @@ func (odr *OCIDistRepo) BlobHead(layer *ispec.Descriptor) error {
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -398,15 +546,66 @@ func (odr *OCIDistRepo) BlobHead(layer *ispec.Descriptor) error {
 		return fmt.Errorf("Failed to find blob, StatusCode: %d", resp.StatusCode())
 	}
 
+	if layer.Size == 0 && resp.RawResponse.ContentLength > 0 {
+		layer.Size = resp.RawResponse.ContentLength
+	}
 	return nil
 }
 
+// MountBlob links layer from repository fromRepo of the same registry
+// instead of uploading it, false if the registry would not mount it.
+func (odr *OCIDistRepo) MountBlob(layer *ispec.Descriptor, fromRepo string) (bool, error) {
+	url := odr.BasePath()
+	repoPath := odr.RepoPath()
+
+	client, err := reggie.NewClient(url,
+		reggie.WithDebug(odr.config.Debug),
+		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
+		reggie.WithDefaultName(repoPath),
+		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
+	)
+	if err != nil {
+		return false, fmt.Errorf("Failed to create client for %s: %s", url, err)
+	}
+
+	log.WithFields(log.Fields{
+		"url":      url,
+		"repoPath": repoPath,
+		"from":     fromRepo,
+		"digest":   layer.Digest,
+	}).Debug("OCIDist.MountBlob() created new client")
+
+	req := client.NewRequest(reggie.POST, "/v2/<name>/blobs/uploads/").
+		SetQueryParam("mount", layer.Digest.String()).
+		SetQueryParam("from", fromRepo)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("Failed to mount blob: %s", err)
+	}
+
+	switch resp.StatusCode() {
+	case 201:
+		return true, nil
+	case 202:
+		// the registry opened an upload session instead, nothing will use it
+		cancel := client.NewRequest(reggie.DELETE, resp.GetRelativeLocation())
+		if _, err := client.Do(cancel); err != nil {
+			log.Debugf("OCIDist.MountBlob() failed to cancel upload: %s", err)
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("Failed to mount blob '%s', StatusCode: %d", layer.Digest, resp.StatusCode())
+}
+
 func (odr *OCIDistRepo) GetRepositories() ([]string, error) {
 	url := odr.BasePath()
 
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 	)
 
@@ -430,6 +629,11 @@ func (odr *OCIDistRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
 		"blobSize": len(blob),
 	}).Debug("OCIDist.PutBlob() called")
 
+	return odr.PutBlobReader(layer, bytes.NewReader(blob))
+}
+
+// PutBlobReader uploads layer.Size bytes of reader as the blob for layer
+func (odr *OCIDistRepo) PutBlobReader(layer *ispec.Descriptor, reader io.Reader) error {
 	// if blob already exists, skip put
 	if err := odr.BlobHead(layer); err == nil {
 		log.WithFields(log.Fields{
@@ -443,9 +647,13 @@ func (odr *OCIDistRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
 	client, err := reggie.NewClient(url,
 		reggie.WithDebug(odr.config.Debug),
 		reggie.WithUserAgent(UserAgent),
+		reggie.WithUsernamePassword(odr.config.Username, odr.config.Password),
 		reggie.WithInsecureSkipTLSVerify(!odr.config.TLSVerify), // skip TLS verification
 		reggie.WithDefaultName(repoPath),
 	)
+	if err != nil {
+		return fmt.Errorf("Failed to create client for %s: %s", url, err)
+	}
 
 	log.WithFields(log.Fields{
 		"url":      url,
@@ -466,12 +674,13 @@ func (odr *OCIDistRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
 
 	// FIXME: attempt anonymous blob mount?
 
-	// upload in one chunk
+	// upload in one chunk, streamed from reader with a known length rather
+	// than chunked encoding
+	client.SetTransport(&sizedTransport{base: client.GetClient().Transport, size: layer.Size})
 	req = client.NewRequest(reggie.PUT, resp.GetRelativeLocation()).
 		SetHeader("Content-Type", "application/octet-stream").
-		SetHeader("Content-Length", fmt.Sprintf("%d", layer.Size)).
 		SetQueryParam("digest", layer.Digest.String()).
-		SetBody(blob)
+		SetBody(reader)
 
 	log.WithFields(log.Fields{
 		"uploadURL": resp.GetRelativeLocation(),
@@ -493,89 +702,20 @@ func (odr *OCIDistRepo) PutBlob(layer *ispec.Descriptor, blob []byte) error {
 	return nil
 }
 
-func (odr *OCIDistRepo) PutArtifact(artifactName, artifactType string, artifactBlob []byte) error {
-	emptyConfig := ispec.Descriptor{
-		MediaType: "application/vnd.oci.empty.v1+json",
-		Size:      2,
-		Digest:    digest.FromBytes([]byte("{}")),
-	}
-
-	log.WithFields(log.Fields{
-		"artifactName": artifactName,
-		"artifactType": artifactType,
-	}).Debug("OCIDist.PutArtifact() called")
-
-	// create and upload artifact blob first
-	blobs := []ispec.Descriptor{
-		{
-			MediaType: "application/octet-stream",
-			Size:      int64(len(artifactBlob)),
-			Digest:    digest.FromBytes(artifactBlob),
-			Annotations: map[string]string{
-				ispec.AnnotationTitle: artifactName,
-			},
-		},
-	}
-
-	log.WithFields(log.Fields{
-		"blob.Digest": blobs[0].Digest.String(),
-	}).Debug("OCIDist.PutArtifact() created blob, uploading...")
-
-	// upload empty config
-	if err := odr.PutBlob(&emptyConfig, []byte("{}")); err != nil {
-		return fmt.Errorf("Failed to put empty config blob: %s", err)
-	}
-
-	// upload blob
-	if err := odr.PutBlob(&blobs[0], artifactBlob); err != nil {
-		return fmt.Errorf("Failed to put artifact blob: %s", err)
-	}
-
-	log.WithFields(log.Fields{
-		"blob.Digest": blobs[0].Digest.String(),
-	}).Debug("OCIDist.PutArtifact() upload OK")
-
-	// create a manifest referencing the uploaded blob in its layers
-	manifest := ispec.Manifest{
-		MediaType:    ispec.MediaTypeImageManifest,
-		ArtifactType: artifactType,
-		Config:       emptyConfig,
-		Layers:       blobs,
-		Versioned:    ManifestV2,
-	}
-
-	// check if there is an existing manifest, if so, fetch and reference this
-	// manifest on subsequent artifacts
-	if err := odr.ManifestHead(); err == nil {
-		log.WithFields(log.Fields{
-			"err": err,
-		}).Debug("OCIDist.PutArtifact() manifest HEAD OK, getting manifest with digest")
-
-		refManifest, refMBytes, err := odr.GetManifest()
-		if err != nil {
-			return fmt.Errorf("Failed to get subject manifest: %s", err)
-		}
-		subject := ispec.Descriptor{
-			MediaType: refManifest.MediaType,
-			Digest:    digest.FromBytes(refMBytes),
-			Size:      int64(len(refMBytes)),
-		}
-		manifest.Subject = &subject
-		log.WithFields(log.Fields{
-			"Manifest.Subject": subject,
-		}).Debug("OCIDist.PutArtifact() existing Manifest, adding Manifest.Subject reference")
-	} else {
-		log.Debugf("OCIDist.PutArtifact() no manifest created yet, skipping subject referrers")
-	}
-
-	log.WithFields(log.Fields{
-		"manifest.Config.Digest": manifest.Config.Digest.String(),
-	}).Debug("OCIDist.PutArtifact() created manifest, calling Put Manifest")
+// sizedTransport sends a streamed request body with a known length
+type sizedTransport struct {
+	base http.RoundTripper
+	size int64
+}
 
-	// put the manifest pointing to artifact
-	if err := odr.PutManifest(&manifest); err != nil {
-		return fmt.Errorf("Failed to put artifact manifest: %s", err)
+func (st *sizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.ContentLength == 0 {
+		req = req.Clone(req.Context())
+		req.ContentLength = st.size
 	}
+	return st.base.RoundTrip(req)
+}
 
-	return nil
+func (odr *OCIDistRepo) PutArtifact(artifactName, artifactType string, artifactBlob []byte) error {
+	return putArtifact(odr, artifactName, artifactType, artifactBlob)
 }
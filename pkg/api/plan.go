@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	PlanCreate    = "create"
+	PlanOverwrite = "overwrite"
+	PlanUnchanged = "unchanged"
+)
+
+type PlannedManifest struct {
+	Ref       string        `json:"ref,omitempty"`
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	Action    string        `json:"action"`
+	// the digest an overwritten tag pointed at
+	Previous digest.Digest `json:"previous,omitempty"`
+	// written with a new digest, known only once its layers are recompressed
+	Rewritten bool `json:"rewritten,omitempty"`
+}
+
+type PlannedBlob struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	// recompressed before sending, Size is that of the source blob
+	Recompress string `json:"recompress,omitempty"`
+}
+
+// CopyPlan is what a NativeCopy would write, the blobs missing at the
+// destination and every manifest with what writing it would do.
+type CopyPlan struct {
+	Source    string            `json:"source"`
+	Dest      string            `json:"dest"`
+	Manifests []PlannedManifest `json:"manifests"`
+	Blobs     []PlannedBlob     `json:"blobs"`
+	// blobs already at the destination
+	Present int `json:"present"`
+	// bytes of missing blobs and of manifests that are not unchanged
+	Bytes int64 `json:"bytes"`
+}
+
+// PlanNativeCopy resolves everything NativeCopy would copy and checks it
+// against the destination without writing anything.
+func PlanNativeCopy(src, dest OCIAPI, opts NativeCopyOpts) (*CopyPlan, error) {
+	nc, err := newNativeCopy(src, dest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	nc.plan = &CopyPlan{
+		Source:    src.SourceURL(),
+		Dest:      dest.SourceURL(),
+		Manifests: []PlannedManifest{},
+		Blobs:     []PlannedBlob{},
+	}
+	if _, err := nc.run(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nc.plan.Blobs, func(i, j int) bool {
+		return nc.plan.Blobs[i].Digest < nc.plan.Blobs[j].Digest
+	})
+	return nc.plan, nil
+}
+
+// planManifest records writing desc to ref at the destination, by digest if
+// ref is empty.  A rewritten desc is the source manifest.
+func (nc *nativeCopy) planManifest(ref string, desc ispec.Descriptor, rewritten bool) {
+	planned := PlannedManifest{
+		Ref:       ref,
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Action:    PlanCreate,
+		Rewritten: rewritten,
+	}
+
+	if ref == "" && !rewritten {
+		planned.Ref = desc.Digest.String()
+	}
+	if planned.Ref != "" {
+		if _, content, err := nc.dest.GetManifestBytes(planned.Ref); err == nil {
+			planned.Action = PlanUnchanged
+			if existing := digest.FromBytes(content); existing != desc.Digest || rewritten {
+				planned.Action = PlanOverwrite
+				planned.Previous = existing
+			}
+		}
+	}
+
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	nc.plan.Manifests = append(nc.plan.Manifests, planned)
+	if planned.Action != PlanUnchanged {
+		nc.plan.Bytes += planned.Size
+	}
+}
+
+// planBlob records a blob, unless it is already at the destination
+func (nc *nativeCopy) planBlob(desc ispec.Descriptor, recompress string) {
+	nc.destSem <- struct{}{}
+	err := nc.dest.BlobHead(&desc)
+	<-nc.destSem
+
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	if err == nil && recompress == "" {
+		nc.plan.Present++
+		return
+	}
+	nc.plan.Blobs = append(nc.plan.Blobs, PlannedBlob{
+		Digest:     desc.Digest,
+		MediaType:  desc.MediaType,
+		Size:       desc.Size,
+		Recompress: recompress,
+	})
+	nc.plan.Bytes += desc.Size
+}
+
+// Print writes the plan for people to read
+func (cp *CopyPlan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Plan to copy %s to %s\n", cp.Source, cp.Dest)
+	for _, m := range cp.Manifests {
+		ref, dgst := m.Ref, m.Digest.String()
+		if m.Rewritten {
+			dgst = "rewritten from " + dgst
+		}
+		if ref == "" || ref == m.Digest.String() {
+			ref = "by digest"
+		}
+		fmt.Fprintf(w, "  %-9s manifest %s %s", m.Action, ref, dgst)
+		if m.Previous != "" {
+			fmt.Fprintf(w, " (was %s)", m.Previous)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, b := range cp.Blobs {
+		fmt.Fprintf(w, "  send      blob %s %s", b.Digest, units.BytesSize(float64(b.Size)))
+		if b.Recompress != "" {
+			fmt.Fprintf(w, " (recompressed as %s)", b.Recompress)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d blobs missing, %d present, %s to send\n", len(cp.Blobs), cp.Present, units.BytesSize(float64(cp.Bytes)))
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/raharper/ocidist/pkg/image"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// HostPlatform is the platform images are selected for from an index
+func HostPlatform() ispec.Platform {
+	return ispec.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// ResolveManifest returns the image manifest at ref, or from an index the
+// image for platform, along with its descriptor.
+func ResolveManifest(ociApi OCIAPI, ref string, platform ispec.Platform) (ispec.Descriptor, []byte, error) {
+	mediaType, content, err := ociApi.GetManifestBytes(ref)
+	if err != nil {
+		return ispec.Descriptor{}, []byte{}, fmt.Errorf("Failed to get manifest '%s': %s", ref, err)
+	}
+
+	if isIndexMediaType(mediaType) {
+		var index ispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return ispec.Descriptor{}, []byte{}, fmt.Errorf("Failed to parse index '%s': %s", ref, err)
+		}
+
+		for _, child := range index.Manifests {
+			if image.PlatformMatches(platform, child.Platform) {
+				return ResolveManifest(ociApi, child.Digest.String(), platform)
+			}
+		}
+		return ispec.Descriptor{}, []byte{}, fmt.Errorf("No image for platform %s/%s in index '%s'", platform.OS, platform.Architecture, ref)
+	}
+
+	desc := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+	return desc, content, nil
+}
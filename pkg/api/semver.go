@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+type semverComparison struct {
+	op      string
+	version string
+}
+
+// SemverRange matches tags against alternatives separated by "||", each a
+// space separated list of comparisons that must all hold:
+//
+//	">=1.2.0 <2.0.0 || >=3.0.0-rc.1"
+//
+// Comparisons are =, >, >=, < or <=, a bare version means =.  Tags with or
+// without a leading 'v' are compared, tags that are not semver never match.
+type SemverRange struct {
+	alternatives [][]semverComparison
+}
+
+func canonicalSemver(version string) string {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version
+}
+
+func ParseSemverRange(expr string) (*SemverRange, error) {
+	sr := &SemverRange{}
+	for _, alternative := range strings.Split(expr, "||") {
+		comparisons := []semverComparison{}
+		for _, tok := range strings.Fields(alternative) {
+			op := strings.TrimRight(tok, "0123456789.-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+			if op == "" {
+				op = "="
+			}
+			switch op {
+			case "=", ">", ">=", "<", "<=":
+			default:
+				return nil, fmt.Errorf("Invalid semver comparison '%s' in range '%s'", tok, expr)
+			}
+
+			version := canonicalSemver(strings.TrimPrefix(tok, op))
+			if !semver.IsValid(version) {
+				return nil, fmt.Errorf("Invalid semver version '%s' in range '%s'", tok, expr)
+			}
+			comparisons = append(comparisons, semverComparison{op: op, version: version})
+		}
+		if len(comparisons) == 0 {
+			return nil, fmt.Errorf("Empty alternative in semver range '%s'", expr)
+		}
+		sr.alternatives = append(sr.alternatives, comparisons)
+	}
+	return sr, nil
+}
+
+// Matches reports if tag is a semver version within the range
+func (sr *SemverRange) Matches(tag string) bool {
+	version := canonicalSemver(tag)
+	if !semver.IsValid(version) {
+		return false
+	}
+
+	for _, comparisons := range sr.alternatives {
+		matched := true
+		for _, c := range comparisons {
+			cmp := semver.Compare(version, c.version)
+			switch c.op {
+			case "=":
+				matched = cmp == 0
+			case ">":
+				matched = cmp > 0
+			case ">=":
+				matched = cmp >= 0
+			case "<":
+				matched = cmp < 0
+			case "<=":
+				matched = cmp <= 0
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
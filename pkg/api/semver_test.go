@@ -0,0 +1,55 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+)
+
+func TestSemverRange(t *testing.T) {
+	for _, tc := range []struct {
+		expr     string
+		matching []string
+		other    []string
+	}{
+		{expr: ">=1.2.0 <2.0.0", matching: []string{"1.2.0", "v1.9.9", "1.10.0"}, other: []string{"1.1.9", "2.0.0", "latest"}},
+		{expr: ">=1.2.0 <2.0.0 || >=3.0.0-rc.1", matching: []string{"1.2.0", "3.0.0-rc.1", "3.0.0", "v4.1.0"}, other: []string{"2.5.0", "3.0.0-beta.1"}},
+		// a bare version means =
+		{expr: "1.2.3", matching: []string{"1.2.3", "v1.2.3"}, other: []string{"1.2.4", "1.2.3-rc.1"}},
+		{expr: "v1.2.3 || 1.4.0", matching: []string{"1.2.3", "1.4.0"}, other: []string{"1.3.0"}},
+		{expr: ">1.0.0 <=1.1.0", matching: []string{"1.0.1", "1.1.0"}, other: []string{"1.0.0", "1.1.1"}},
+		// prereleases sort before their release
+		{expr: "<2.0.0", matching: []string{"1.0.0", "2.0.0-rc.1"}, other: []string{"2.0.0"}},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			sr, err := api.ParseSemverRange(tc.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse range: %s", err)
+			}
+			for _, tag := range tc.matching {
+				if !sr.Matches(tag) {
+					t.Errorf("%s does not match %q", tag, tc.expr)
+				}
+			}
+			for _, tag := range tc.other {
+				if sr.Matches(tag) {
+					t.Errorf("%s matches %q", tag, tc.expr)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSemverRangeInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		">=1.0.0 ||",
+		"~1.0.0",
+		"=>1.0.0",
+		">=one",
+	} {
+		if _, err := api.ParseSemverRange(expr); err == nil {
+			t.Errorf("Parsed invalid range %q", expr)
+		}
+	}
+}
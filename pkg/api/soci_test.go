@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+)
+
+func putTestSOCI(t *testing.T, ociApi api.OCIAPI, artifacts api.SOCIArtifacts) {
+	t.Helper()
+	sig, err := artifacts.SignatureBlob()
+	if err != nil {
+		t.Fatalf("Failed to decode signature: %s", err)
+	}
+	for _, a := range []struct {
+		name, artifactType string
+		blob               []byte
+	}{
+		// the install artifact first, it becomes the subject of the others
+		{"install.json", api.SOCIArtifactInstall, []byte(artifacts.Install)},
+		{"pubkeycrt.pem", api.SOCIArtifactPubKeyCrt, []byte(artifacts.PubKeyCrt)},
+		{"install.json.signature", api.SOCIArtifactSignature, sig},
+	} {
+		aType, err := api.SOCIArtifactType("atomix", a.artifactType)
+		if err != nil {
+			t.Fatalf("Failed to get artifact type: %s", err)
+		}
+		if err := ociApi.PutArtifact(a.name, aType, a.blob); err != nil {
+			t.Fatalf("Failed to put %s: %s", a.name, err)
+		}
+	}
+}
+
+func TestSOCIRoundTrip(t *testing.T) {
+	ociApi := apitest.NewMemRepo(t, "product/svc:v1")
+	artifacts, err := api.NewSOCIArtifacts([]byte(`{"service":"svc"}`), []byte("certificate"), []byte("signature"))
+	if err != nil {
+		t.Fatalf("Failed to create artifacts: %s", err)
+	}
+	putTestSOCI(t, ociApi, artifacts)
+
+	sociRef, err := api.NewSOCIRef(ociApi)
+	if err != nil {
+		t.Fatalf("Failed to read SOCI: %s", err)
+	}
+	if sociRef.Install.Size != int64(len(artifacts.Install)) {
+		t.Errorf("Install layer has size %d, expected %d", sociRef.Install.Size, len(artifacts.Install))
+	}
+	if sociRef.PubKeyCrt.Digest == "" || sociRef.Signature.Digest == "" {
+		t.Errorf("Referrers not found, pubkeycrt %q signature %q", sociRef.PubKeyCrt.Digest, sociRef.Signature.Digest)
+	}
+
+	got, err := sociRef.GetArtifacts()
+	if err != nil {
+		t.Fatalf("Failed to get artifacts: %s", err)
+	}
+	if got != artifacts {
+		t.Errorf("Got artifacts %+v, expected %+v", got, artifacts)
+	}
+}
+
+func TestSOCIRefNotSOCI(t *testing.T) {
+	ociApi := apitest.NewMemRepo(t, "product/svc:v1")
+	apitest.PushImage(t, ociApi)
+
+	if _, err := api.NewSOCIRef(ociApi); err == nil {
+		t.Errorf("NewSOCIRef of an image succeeded")
+	}
+
+	missing := apitest.NewMemRepo(t, "product/svc:v2")
+	if _, err := api.NewSOCIRef(missing); err == nil {
+		t.Errorf("NewSOCIRef of a missing tag succeeded")
+	}
+}
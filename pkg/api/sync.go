@@ -0,0 +1,508 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/raharper/ocidist/pkg/image"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+type SyncOpts struct {
+	SrcConfig  *OCIAPIConfig
+	DestConfig *OCIAPIConfig
+
+	// tags are synced if they match any include, all if empty, and no exclude
+	IncludeTags []*regexp.Regexp
+	ExcludeTags []*regexp.Regexp
+	// and if set, are a version within this range
+	TagSemver *SemverRange
+	// the same for repositories when syncing a whole registry
+	IncludeRepos []*regexp.Regexp
+	ExcludeRepos []*regexp.Regexp
+
+	// delete destination tags that no longer exist at the source
+	Delete bool
+	// record synced digests here and skip them on the next run
+	StateFile string
+	// containers-policy.json every copied source must satisfy
+	PolicyPath string
+	// plan every copy and delete without writing anything, or the state file
+	DryRun bool
+
+	// indexes are synced whole unless Copy.Platforms is set
+	Copy NativeCopyOpts
+}
+
+const (
+	SyncCopied  = "copied"
+	SyncSkipped = "skipped"
+	SyncDeleted = "deleted"
+	SyncFailed  = "failed"
+	// what a dry run would have done
+	SyncWouldCopy   = "would-copy"
+	SyncWouldDelete = "would-delete"
+)
+
+type SyncResult struct {
+	Source string        `json:"source,omitempty"`
+	Dest   string        `json:"dest"`
+	Digest digest.Digest `json:"digest,omitempty"`
+	Action string        `json:"action"`
+	Error  string        `json:"error,omitempty"`
+	// what a dry run would copy
+	Plan *CopyPlan `json:"plan,omitempty"`
+
+	destRepo string
+}
+
+type SyncReport struct {
+	Results []SyncResult `json:"results"`
+}
+
+// Count returns the number of results with action
+func (sr *SyncReport) Count(action string) int {
+	count := 0
+	for _, result := range sr.Results {
+		if result.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// PlannedBytes returns the bytes every planned copy would send, counting a
+// blob missing from a destination repository once however many tags share it.
+func (sr *SyncReport) PlannedBytes() int64 {
+	var total int64
+	planned := map[string]bool{}
+	for _, result := range sr.Results {
+		if result.Plan == nil {
+			continue
+		}
+		total += result.Plan.Bytes
+		for _, blob := range result.Plan.Blobs {
+			key := result.destRepo + "@" + blob.Digest.String()
+			if planned[key] {
+				total -= blob.Size
+			}
+			planned[key] = true
+		}
+	}
+	return total
+}
+
+type SyncStateEntry struct {
+	Dest   string        `json:"dest"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// SyncState maps each source tag URL to what was last synced from it
+type SyncState struct {
+	Synced map[string]SyncStateEntry `json:"synced"`
+}
+
+func LoadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{Synced: map[string]SyncStateEntry{}}
+	if path == "" {
+		return state, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("Failed to read sync state %q: %s", path, err)
+	}
+
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("Failed to parse sync state %q: %s", path, err)
+	}
+	if state.Synced == nil {
+		state.Synced = map[string]SyncStateEntry{}
+	}
+	return state, nil
+}
+
+// Save writes the state through a temporary file so an interrupted sync
+// never leaves a truncated state behind.
+func (ss *SyncState) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(ss, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal sync state: %s", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("Failed to write sync state %q: %s", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to write sync state %q: %s", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to write sync state %q: %s", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// syncRepo is a pair of repository URLs without a tag
+type syncRepo struct {
+	src  string
+	dest string
+}
+
+func matchFilters(name string, include, exclude []*regexp.Regexp) bool {
+	included := len(include) == 0
+	for _, re := range include {
+		if re.MatchString(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, re := range exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (opts *SyncOpts) tagMatches(tag string) bool {
+	if opts.TagSemver != nil && !opts.TagSemver.Matches(tag) {
+		return false
+	}
+	return matchFilters(tag, opts.IncludeTags, opts.ExcludeTags)
+}
+
+// isRegistryURL reports if the URL names a registry rather than a repository
+func isRegistryURL(api OCIAPI) bool {
+	switch api.Type() {
+	case OCIDistRepoType, MemRepoType:
+		return api.RepoPath() == ""
+	}
+	return false
+}
+
+// joinRepoURL appends a repository name to a registry or layout URL.  Layouts
+// and archives keep repositories as reference name prefixes.
+func joinRepoURL(rawURL, repo string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse url '%s': %s", rawURL, err)
+	}
+
+	switch OCIRepoType(u.Scheme) {
+	case OCIDirRepoType, OCIArchiveRepoType:
+		return fmt.Sprintf("%s:%s", rawURL, repo), nil
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimRight(rawURL, "/"), repo), nil
+}
+
+// syncTags lists the tags of a repository URL.  Layout and archive indexes
+// hold every reference name, only those of the URL's image are its tags.
+func syncTags(api OCIAPI) ([]string, error) {
+	tags, err := api.GetRepoTags()
+	if err != nil {
+		return []string{}, err
+	}
+
+	prefix := ""
+	switch api.Type() {
+	case OCIDirRepoType:
+		prefix = api.ImageName()
+	case OCIArchiveRepoType:
+		prefix = api.RepoTag()
+	}
+	if prefix == "" {
+		return tags, nil
+	}
+
+	repoTags := []string{}
+	for _, tag := range tags {
+		if t, ok := strings.CutPrefix(tag, prefix+":"); ok {
+			repoTags = append(repoTags, t)
+		}
+	}
+	return repoTags, nil
+}
+
+// Sync mirrors every tag of the source repository, or of every repository of
+// the source registry, to the destination.  Tags whose digest already matches
+// the destination, or the state file, are skipped.  Failed tags do not stop
+// the sync, they are reported and returned as an error at the end.
+func Sync(rawSrc, rawDest string, opts SyncOpts) (*SyncReport, error) {
+	if opts.SrcConfig == nil {
+		opts.SrcConfig = &OCIAPIConfig{}
+	}
+	if opts.DestConfig == nil {
+		opts.DestConfig = &OCIAPIConfig{}
+	}
+	if opts.Copy.Progress == nil {
+		opts.Copy.Progress = io.Discard
+	}
+	opts.Copy.All = len(opts.Copy.Platforms) == 0
+
+	state, err := LoadSyncState(opts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := syncRepos(rawSrc, rawDest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{Results: []SyncResult{}}
+	for _, repo := range repos {
+		if err := syncRepository(repo, opts, state, report); err != nil {
+			report.Results = append(report.Results, SyncResult{Source: repo.src, Dest: repo.dest, Action: SyncFailed, Error: err.Error()})
+			fmt.Fprintf(opts.Copy.Progress, "Failed to sync %s: %s\n", repo.src, err)
+		}
+	}
+
+	if failed := report.Count(SyncFailed); failed > 0 {
+		return report, fmt.Errorf("Failed to sync %d of %d tags", failed, len(report.Results))
+	}
+	return report, nil
+}
+
+func syncRepos(rawSrc, rawDest string, opts SyncOpts) ([]syncRepo, error) {
+	src, err := NewOCIAPI(rawSrc, opts.SrcConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRegistryURL(src) {
+		return []syncRepo{{src: rawSrc, dest: rawDest}}, nil
+	}
+
+	names, err := src.GetRepositories()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list repositories at %s: %s", rawSrc, err)
+	}
+
+	repos := []syncRepo{}
+	for _, name := range names {
+		if !matchFilters(name, opts.IncludeRepos, opts.ExcludeRepos) {
+			continue
+		}
+		srcRepo, err := joinRepoURL(rawSrc, name)
+		if err != nil {
+			return nil, err
+		}
+		destRepo, err := joinRepoURL(rawDest, name)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, syncRepo{src: srcRepo, dest: destRepo})
+	}
+	return repos, nil
+}
+
+func syncRepository(repo syncRepo, opts SyncOpts, state *SyncState, report *SyncReport) error {
+	src, err := NewOCIAPI(repo.src, opts.SrcConfig)
+	if err != nil {
+		return err
+	}
+
+	tags, err := syncTags(src)
+	if err != nil {
+		return fmt.Errorf("Failed to list tags: %s", err)
+	}
+
+	log.WithFields(log.Fields{
+		"src":  repo.src,
+		"dest": repo.dest,
+		"tags": tags,
+	}).Debug("Sync() syncing repository")
+
+	srcTags := map[string]bool{}
+	for _, tag := range tags {
+		if !opts.tagMatches(tag) {
+			continue
+		}
+		srcTags[tag] = true
+
+		result := syncTag(fmt.Sprintf("%s:%s", repo.src, tag), fmt.Sprintf("%s:%s", repo.dest, tag), opts, state)
+		result.destRepo = repo.dest
+		report.Results = append(report.Results, result)
+	}
+
+	if opts.Delete {
+		return syncDelete(repo, srcTags, opts, state, report)
+	}
+	return nil
+}
+
+func syncTag(srcURL, destURL string, opts SyncOpts, state *SyncState) SyncResult {
+	result := SyncResult{Source: srcURL, Dest: destURL}
+	fail := func(err error) SyncResult {
+		result.Action = SyncFailed
+		result.Error = err.Error()
+		fmt.Fprintf(opts.Copy.Progress, "Failed to sync %s: %s\n", srcURL, err)
+		return result
+	}
+
+	src, err := NewOCIAPI(srcURL, opts.SrcConfig)
+	if err != nil {
+		return fail(err)
+	}
+	dest, err := NewOCIAPI(destURL, opts.DestConfig)
+	if err != nil {
+		return fail(err)
+	}
+
+	mediaType, content, err := src.GetManifestBytes(src.RepoTag())
+	if err != nil {
+		return fail(fmt.Errorf("Failed to get source manifest: %s", err))
+	}
+	// what is checked against the policy and copied, even if the tag moves
+	copyOpts := opts.Copy
+	copyOpts.SourceDigest = digest.FromBytes(content)
+
+	// compare against the filtered index NativeCopy will write
+	if len(opts.Copy.Platforms) > 0 && isIndexMediaType(mediaType) {
+		var index ispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return fail(fmt.Errorf("Failed to parse index: %s", err))
+		}
+		if content, err = filterIndexPlatforms(&index, opts.Copy.Platforms); err != nil {
+			return fail(err)
+		}
+	}
+	result.Digest = digest.FromBytes(content)
+
+	// referrers may have been added to a tag that did not move
+	syncReferrers := func() error {
+		if !opts.Copy.Referrers {
+			return nil
+		}
+		referrerOpts := copyOpts
+		referrerOpts.ReferrersOnly = true
+		if opts.DryRun {
+			result.Plan, err = PlanNativeCopy(src, dest, referrerOpts)
+			return err
+		}
+		return NativeCopy(src, dest, referrerOpts)
+	}
+
+	if entry, ok := state.Synced[srcURL]; ok && entry.Dest == destURL && entry.Digest == result.Digest {
+		result.Action = SyncSkipped
+		fmt.Fprintf(opts.Copy.Progress, "Skipping %s (synced %s)\n", srcURL, result.Digest)
+		if err := syncReferrers(); err != nil {
+			return fail(err)
+		}
+		return result
+	}
+
+	if _, destContent, err := dest.GetManifestBytes(dest.RepoTag()); err == nil && digest.FromBytes(destContent) == result.Digest {
+		result.Action = SyncSkipped
+		fmt.Fprintf(opts.Copy.Progress, "Skipping %s (up to date %s)\n", srcURL, result.Digest)
+		if err := syncReferrers(); err != nil {
+			return fail(err)
+		}
+	} else {
+		fmt.Fprintf(opts.Copy.Progress, "Syncing %s to %s\n", srcURL, destURL)
+		policyOpts := image.ImageCopyOpts{
+			SrcUsername: opts.SrcConfig.Username,
+			SrcPassword: opts.SrcConfig.Password,
+			SrcSkipTLS:  !opts.SrcConfig.TLSVerify,
+			PolicyPath:  opts.PolicyPath,
+		}
+		if err := CheckPolicy(srcURL, copyOpts.SourceDigest, policyOpts); err != nil {
+			return fail(err)
+		}
+		if opts.DryRun {
+			if result.Plan, err = PlanNativeCopy(src, dest, copyOpts); err != nil {
+				return fail(err)
+			}
+			result.Action = SyncWouldCopy
+			return result
+		}
+		if err := NativeCopy(src, dest, copyOpts); err != nil {
+			return fail(err)
+		}
+		result.Action = SyncCopied
+	}
+
+	if opts.DryRun {
+		return result
+	}
+	state.Synced[srcURL] = SyncStateEntry{Dest: destURL, Digest: result.Digest}
+	if err := state.Save(opts.StateFile); err != nil {
+		return fail(err)
+	}
+	return result
+}
+
+// syncDelete removes destination tags that pass the tag filters but are no
+// longer at the source.
+func syncDelete(repo syncRepo, srcTags map[string]bool, opts SyncOpts, state *SyncState, report *SyncReport) error {
+	dest, err := NewOCIAPI(repo.dest, opts.DestConfig)
+	if err != nil {
+		return err
+	}
+
+	destTags, err := syncTags(dest)
+	if err != nil {
+		// nothing was ever synced to a repository that does not exist
+		log.WithFields(log.Fields{
+			"dest": repo.dest,
+			"err":  err,
+		}).Debug("Sync() no destination tags to delete")
+		return nil
+	}
+
+	for _, tag := range destTags {
+		if srcTags[tag] || !opts.tagMatches(tag) {
+			continue
+		}
+
+		destURL := fmt.Sprintf("%s:%s", repo.dest, tag)
+		if opts.DryRun {
+			report.Results = append(report.Results, SyncResult{Dest: destURL, Action: SyncWouldDelete})
+			fmt.Fprintf(opts.Copy.Progress, "Would delete %s\n", destURL)
+			continue
+		}
+		result := SyncResult{Dest: destURL, Action: SyncDeleted}
+
+		destTag, err := NewOCIAPI(destURL, opts.DestConfig)
+		if err == nil {
+			err = destTag.DeleteManifest(destTag.RepoTag())
+		}
+		if err != nil {
+			result.Action = SyncFailed
+			result.Error = fmt.Sprintf("Failed to delete tag: %s", err)
+			fmt.Fprintf(opts.Copy.Progress, "Failed to delete %s: %s\n", destURL, err)
+		} else {
+			fmt.Fprintf(opts.Copy.Progress, "Deleted %s\n", destURL)
+			delete(state.Synced, fmt.Sprintf("%s:%s", repo.src, tag))
+		}
+		report.Results = append(report.Results, result)
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return state.Save(opts.StateFile)
+}
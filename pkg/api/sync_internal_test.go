@@ -0,0 +1,90 @@
+package api
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMatchFilters(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		include []string
+		exclude []string
+		matches bool
+	}{
+		{name: "v1.0", matches: true},
+		{name: "v1.0", include: []string{`^v1\.`}, matches: true},
+		{name: "v2.0", include: []string{`^v1\.`}, matches: false},
+		// any include is enough
+		{name: "latest", include: []string{`^v1\.`, `^latest$`}, matches: true},
+		// an exclude wins over an include
+		{name: "v1.0-rc1", include: []string{`^v1\.`}, exclude: []string{`-rc`}, matches: false},
+		{name: "v1.0-rc1", exclude: []string{`-rc`, `-beta`}, matches: false},
+		{name: "v1.0", exclude: []string{`-rc`}, matches: true},
+	} {
+		include := []*regexp.Regexp{}
+		for _, expr := range tc.include {
+			include = append(include, regexp.MustCompile(expr))
+		}
+		exclude := []*regexp.Regexp{}
+		for _, expr := range tc.exclude {
+			exclude = append(exclude, regexp.MustCompile(expr))
+		}
+		if got := matchFilters(tc.name, include, exclude); got != tc.matches {
+			t.Errorf("matchFilters(%s, %v, %v) = %v, expected %v", tc.name, tc.include, tc.exclude, got, tc.matches)
+		}
+	}
+}
+
+func TestSyncReportPlannedBytes(t *testing.T) {
+	shared := PlannedBlob{Digest: digest.FromString("shared"), Size: 100}
+	own := PlannedBlob{Digest: digest.FromString("own"), Size: 10}
+	plan := func(manifestBytes int64, blobs ...PlannedBlob) *CopyPlan {
+		cp := &CopyPlan{Blobs: blobs, Bytes: manifestBytes}
+		for _, blob := range blobs {
+			cp.Bytes += blob.Size
+		}
+		return cp
+	}
+
+	for _, tc := range []struct {
+		name     string
+		results  []SyncResult
+		expected int64
+	}{
+		{name: "empty", expected: 0},
+		{
+			name: "skipped",
+			results: []SyncResult{
+				{Action: SyncSkipped, destRepo: "dest/a"},
+				{Action: SyncWouldCopy, Plan: plan(1, own), destRepo: "dest/a"},
+			},
+			expected: 11,
+		},
+		// tags of one repository send a shared blob once
+		{
+			name: "shared",
+			results: []SyncResult{
+				{Action: SyncWouldCopy, Plan: plan(1, shared), destRepo: "dest/a"},
+				{Action: SyncWouldCopy, Plan: plan(1, shared, own), destRepo: "dest/a"},
+			},
+			expected: 112,
+		},
+		// but once to every repository
+		{
+			name: "repositories",
+			results: []SyncResult{
+				{Action: SyncWouldCopy, Plan: plan(1, shared), destRepo: "dest/a"},
+				{Action: SyncWouldCopy, Plan: plan(1, shared), destRepo: "dest/b"},
+			},
+			expected: 202,
+		},
+	} {
+		report := &SyncReport{Results: tc.results}
+		if got := report.PlannedBytes(); got != tc.expected {
+			t.Errorf("%s: PlannedBytes() = %d, expected %d", tc.name, got, tc.expected)
+		}
+	}
+}
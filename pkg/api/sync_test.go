@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+)
+
+func TestSyncSkippedTagReferrers(t *testing.T) {
+	src := apitest.NewMemRepo(t, "src/img:v1")
+	subject := apitest.PushImage(t, src)
+	srcURL := "mem://" + t.Name() + "/src/img"
+	destURL := "mem://" + t.Name() + "/dest/img"
+	opts := api.SyncOpts{
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Copy:      api.NativeCopyOpts{Referrers: true},
+	}
+
+	report, err := api.Sync(srcURL, destURL, opts)
+	if err != nil {
+		t.Fatalf("Failed to sync: %s", err)
+	}
+	if report.Count(api.SyncCopied) != 1 {
+		t.Fatalf("Got results %+v, expected one copied tag", report.Results)
+	}
+
+	// a signature added after the tag was synced
+	if err := src.PutArtifact("sig", "application/vnd.example.sig", []byte("signature")); err != nil {
+		t.Fatalf("Failed to put artifact: %s", err)
+	}
+
+	dryRun := opts
+	dryRun.DryRun = true
+	report, err = api.Sync(srcURL, destURL, dryRun)
+	if err != nil {
+		t.Fatalf("Failed to plan sync: %s", err)
+	}
+	if report.Count(api.SyncSkipped) != 1 || report.Results[0].Plan == nil || len(report.Results[0].Plan.Manifests) != 1 {
+		t.Fatalf("Got results %+v, expected a skipped tag planning one referrer", report.Results)
+	}
+
+	// skipped through the state file, and again through the destination
+	for _, stateFile := range []string{opts.StateFile, ""} {
+		syncOpts := opts
+		syncOpts.StateFile = stateFile
+		report, err = api.Sync(srcURL, destURL, syncOpts)
+		if err != nil {
+			t.Fatalf("Failed to sync: %s", err)
+		}
+		if report.Count(api.SyncSkipped) != 1 {
+			t.Errorf("Got results %+v, expected one skipped tag", report.Results)
+		}
+
+		dest := apitest.NewMemRepo(t, "dest/img:v1")
+		referrers, err := dest.GetReferrers(&subject)
+		if err != nil {
+			t.Fatalf("Failed to get referrers: %s", err)
+		}
+		if len(referrers.Manifests) != 1 || referrers.Manifests[0].ArtifactType != "application/vnd.example.sig" {
+			t.Errorf("Destination has referrers %v, expected the sig", referrers.Manifests)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/raharper/ocidist/pkg/image"
+)
+
+// SyncConfig is the config file's list of syncs, decoded from YAML like:
+//
+//	registries:
+//	  - host: build.example.com:5000
+//	    tls-verify: false
+//	  - host: edge.example.com
+//	    username: mirror
+//	    password-env: EDGE_PASSWORD
+//	sync:
+//	  - source: ocidist://build.example.com:5000/myrepo/app
+//	    dest: ocidist://edge.example.com/myrepo/app
+//	    tags:
+//	      semver: ">=1.2.0 <2.0.0"
+//	      exclude: ["-rc"]
+//	    platforms: [linux/amd64, linux/arm64]
+type SyncConfig struct {
+	Config `mapstructure:",squash"`
+	Sync   []SyncEntry `mapstructure:"sync"`
+}
+
+type SyncFilter struct {
+	Semver  string   `mapstructure:"semver"`
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+type SyncEntry struct {
+	Name   string     `mapstructure:"name"`
+	Source string     `mapstructure:"source"`
+	Dest   string     `mapstructure:"dest"`
+	Tags   SyncFilter `mapstructure:"tags"`
+	// only Include and Exclude apply to repositories
+	Repos         SyncFilter `mapstructure:"repos"`
+	Platforms     []string   `mapstructure:"platforms"`
+	Referrers     *bool      `mapstructure:"referrers"`
+	ArtifactTypes []string   `mapstructure:"artifact-types"`
+	Delete        bool       `mapstructure:"delete"`
+	State         string     `mapstructure:"state"`
+}
+
+type SyncEntryReport struct {
+	Name    string `json:"name,omitempty"`
+	Source  string `json:"source"`
+	Dest    string `json:"dest"`
+	Copied  int    `json:"copied"`
+	Skipped int    `json:"skipped"`
+	Deleted int    `json:"deleted"`
+	Failed  int    `json:"failed"`
+	// dry run counts and the bytes the planned copies would send
+	WouldCopy   int          `json:"would-copy,omitempty"`
+	WouldDelete int          `json:"would-delete,omitempty"`
+	Bytes       int64        `json:"bytes,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Results     []SyncResult `json:"results"`
+}
+
+type SyncConfigReport struct {
+	Entries []SyncEntryReport `json:"entries"`
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regex '%s': %s", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// EntryOpts returns the SyncOpts for entry, starting from defaults
+func (sc *SyncConfig) EntryOpts(entry *SyncEntry, defaults SyncOpts) (SyncOpts, error) {
+	opts := defaults
+	if entry.Source == "" || entry.Dest == "" {
+		return opts, fmt.Errorf("Sync entry must have a source and dest")
+	}
+
+	var err error
+	if opts.SrcConfig, err = sc.APIConfig(entry.Source, defaults.SrcConfig); err != nil {
+		return opts, err
+	}
+	if opts.DestConfig, err = sc.APIConfig(entry.Dest, defaults.DestConfig); err != nil {
+		return opts, err
+	}
+
+	if opts.IncludeTags, err = compileRegexps(entry.Tags.Include); err != nil {
+		return opts, err
+	}
+	if opts.ExcludeTags, err = compileRegexps(entry.Tags.Exclude); err != nil {
+		return opts, err
+	}
+	if opts.IncludeRepos, err = compileRegexps(entry.Repos.Include); err != nil {
+		return opts, err
+	}
+	if opts.ExcludeRepos, err = compileRegexps(entry.Repos.Exclude); err != nil {
+		return opts, err
+	}
+
+	opts.TagSemver = nil
+	if entry.Tags.Semver != "" {
+		if opts.TagSemver, err = ParseSemverRange(entry.Tags.Semver); err != nil {
+			return opts, err
+		}
+	}
+
+	if opts.Copy.Platforms, err = image.ParsePlatforms(strings.Join(entry.Platforms, ",")); err != nil {
+		return opts, err
+	}
+
+	if entry.Referrers != nil {
+		opts.Copy.Referrers = *entry.Referrers
+	}
+	opts.Copy.ArtifactTypes = entry.ArtifactTypes
+	opts.Delete = entry.Delete
+	opts.StateFile = entry.State
+	if opts.PolicyPath == "" {
+		opts.PolicyPath = sc.PolicyPath(entry.Source)
+	}
+	return opts, nil
+}
+
+// Run syncs every entry in order.  An entry that fails does not stop the
+// others, every failure is in the report and counted in the error.
+func (sc *SyncConfig) Run(defaults SyncOpts) (*SyncConfigReport, error) {
+	report := &SyncConfigReport{Entries: []SyncEntryReport{}}
+	failed := 0
+	for i := range sc.Sync {
+		entry := &sc.Sync[i]
+		entryReport := SyncEntryReport{Name: entry.Name, Source: entry.Source, Dest: entry.Dest, Results: []SyncResult{}}
+
+		opts, err := sc.EntryOpts(entry, defaults)
+		if err == nil {
+			var syncReport *SyncReport
+			syncReport, err = Sync(entry.Source, entry.Dest, opts)
+			if syncReport != nil {
+				entryReport.Results = syncReport.Results
+				entryReport.Copied = syncReport.Count(SyncCopied)
+				entryReport.Skipped = syncReport.Count(SyncSkipped)
+				entryReport.Deleted = syncReport.Count(SyncDeleted)
+				entryReport.Failed = syncReport.Count(SyncFailed)
+				entryReport.WouldCopy = syncReport.Count(SyncWouldCopy)
+				entryReport.WouldDelete = syncReport.Count(SyncWouldDelete)
+				entryReport.Bytes = syncReport.PlannedBytes()
+			}
+		}
+		if err != nil {
+			entryReport.Error = err.Error()
+			failed++
+		}
+		report.Entries = append(report.Entries, entryReport)
+	}
+
+	if failed > 0 {
+		return report, fmt.Errorf("Failed to sync %d of %d entries", failed, len(sc.Sync))
+	}
+	return report, nil
+}
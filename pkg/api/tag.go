@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// sameRegistry reports if a and b share storage, a registry or mem:// store,
+// or the same layout or archive.
+func sameRegistry(a, b OCIAPI) bool {
+	if registryKey(a) != registryKey(b) {
+		return false
+	}
+	switch a.Type() {
+	case OCIDistRepoType, MemRepoType:
+		return true
+	}
+	return a.RepoPath() == b.RepoPath()
+}
+
+// RetagURL returns rawURL with its tag, or its @digest, replaced by tag
+func RetagURL(rawURL, tag string) (string, error) {
+	if idx := strings.LastIndex(rawURL, "@"); idx >= 0 && !strings.Contains(rawURL[idx:], "/") {
+		if _, err := digest.Parse(rawURL[idx+1:]); err != nil {
+			return "", fmt.Errorf("URL '%s' has an invalid digest: %s", rawURL, err)
+		}
+		return rawURL[:idx] + ":" + tag, nil
+	}
+
+	idx := strings.LastIndex(rawURL, ":")
+	if idx < 0 || strings.Contains(rawURL[idx:], "/") {
+		return "", fmt.Errorf("URL '%s' has no tag to replace", rawURL)
+	}
+	return rawURL[:idx+1] + tag, nil
+}
+
+// Tag points each destination's tag at the source manifest by putting the
+// exact source bytes, so the digest never changes and no layers move.  Each
+// destination must be in the source's registry or layout.  Another repository
+// of the registry gets its blobs mounted from the source repository.
+func Tag(src OCIAPI, dests []OCIAPI, progress io.Writer) (digest.Digest, error) {
+	if progress == nil {
+		progress = io.Discard
+	}
+
+	mediaType, content, err := src.GetManifestBytes(src.RepoTag())
+	if err != nil {
+		return "", fmt.Errorf("Failed to get source manifest: %s", err)
+	}
+	dgst := digest.FromBytes(content)
+
+	for _, dest := range dests {
+		if !sameRegistry(src, dest) {
+			return dgst, fmt.Errorf("Cannot tag '%s' outside of the source registry, use copy", dest.SourceURL())
+		}
+
+		log.WithFields(log.Fields{
+			"src":    src.SourceURL(),
+			"dest":   dest.SourceURL(),
+			"digest": dgst,
+		}).Debug("Tag() tagging manifest")
+
+		// layouts and archives share blobs between every image name
+		if dest.RepoPath() == src.RepoPath() {
+			if _, err := dest.PutManifestBytes(dest.RepoTag(), mediaType, content); err != nil {
+				return dgst, fmt.Errorf("Failed to tag '%s': %s", dest.SourceURL(), err)
+			}
+		} else {
+			opts := NativeCopyOpts{All: true, PreserveDigests: true, Progress: progress}
+			if err := NativeCopy(src, dest, opts); err != nil {
+				return dgst, fmt.Errorf("Failed to tag '%s': %s", dest.SourceURL(), err)
+			}
+		}
+		fmt.Fprintf(progress, "Tagged %s %s\n", dest.SourceURL(), dgst)
+	}
+	return dgst, nil
+}
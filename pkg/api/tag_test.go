@@ -0,0 +1,37 @@
+package api_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+)
+
+func TestRetagURL(t *testing.T) {
+	dgst := "sha256:" + strings.Repeat("a", 64)
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{url: "ocidist://localhost:5000/repo/img:v1", expected: "ocidist://localhost:5000/repo/img:v2"},
+		{url: "ocidist://localhost:5000/repo/img@" + dgst, expected: "ocidist://localhost:5000/repo/img:v2"},
+		{url: "oci:///images/oci:img:v1", expected: "oci:///images/oci:img:v2"},
+		{url: "mem://store/repo/img:v1", expected: "mem://store/repo/img:v2"},
+		// the port is not a tag
+		{url: "ocidist://localhost:5000/repo/img"},
+		{url: "ocidist://localhost:5000/repo/img@sha256:short"},
+	} {
+		retagged, err := api.RetagURL(tc.url, "v2")
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("RetagURL(%s) returned %s, expected an error", tc.url, retagged)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("RetagURL(%s) failed: %s", tc.url, err)
+		} else if retagged != tc.expected {
+			t.Errorf("RetagURL(%s) returned %s, expected %s", tc.url, retagged, tc.expected)
+		}
+	}
+}
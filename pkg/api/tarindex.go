@@ -0,0 +1,269 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tarLock keeps readers from indexing an archive while it is being appended
+var tarLock sync.RWMutex
+
+// tarEntry records where a regular file's content lives within a tar file.
+type tarEntry struct {
+	offset int64
+	size   int64
+	link   string
+}
+
+// tarIndex maps the files in an uncompressed tar to their offsets so single
+// members can be read without extracting the archive.  Later entries with
+// the same name replace earlier ones, the same as extracting would.
+type tarIndex struct {
+	path    string
+	entries map[string]tarEntry
+	// offset of the end-of-archive marker, where new entries are appended
+	end int64
+	// the archive as it was indexed, to tell when it changed since
+	size    int64
+	modTime time.Time
+}
+
+func tarName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// openTarIndex returns cached if the archive has not changed since it was
+// indexed, or else a new index.
+func openTarIndex(tarPath string, cached *tarIndex) (*tarIndex, error) {
+	tarLock.RLock()
+	defer tarLock.RUnlock()
+	if cached.current() {
+		return cached, nil
+	}
+	return indexTar(tarPath)
+}
+
+// current reports if the archive is unchanged since ti was made
+func (ti *tarIndex) current() bool {
+	if ti == nil {
+		return false
+	}
+	info, err := os.Stat(ti.path)
+	return err == nil && info.Size() == ti.size && info.ModTime().Equal(ti.modTime)
+}
+
+// stamp records the size and time of the archive open as fh
+func (ti *tarIndex) stamp(fh *os.File) error {
+	info, err := fh.Stat()
+	if err != nil {
+		return fmt.Errorf("Failed to stat archive %q: %s", ti.path, err)
+	}
+	ti.size = info.Size()
+	ti.modTime = info.ModTime()
+	return nil
+}
+
+func indexTar(tarPath string) (*tarIndex, error) {
+	fh, err := os.Open(tarPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open archive %q: %s", tarPath, err)
+	}
+	defer fh.Close()
+
+	log.WithFields(log.Fields{
+		"archive": tarPath,
+	}).Debug("indexTar() indexing archive")
+
+	ti := &tarIndex{path: tarPath, entries: map[string]tarEntry{}}
+	if err := ti.stamp(fh); err != nil {
+		return nil, err
+	}
+
+	// the reader seeks over the content of entries on fh
+	tr := tar.NewReader(fh)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read archive %q: %s", tarPath, err)
+		}
+
+		// the header has been consumed, fh is at the start of the data
+		offset, err := fh.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read archive %q: %s", tarPath, err)
+		}
+		entry := tarEntry{offset: offset, size: hdr.Size}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeSymlink:
+			entry.link = tarName(path.Join(path.Dir(tarName(hdr.Name)), hdr.Linkname))
+		case tar.TypeLink:
+			entry.link = tarName(hdr.Linkname)
+		default:
+			continue
+		}
+		ti.entries[tarName(hdr.Name)] = entry
+
+		if end := entry.offset + (entry.size+511)/512*512; end > ti.end {
+			ti.end = end
+		}
+	}
+
+	return ti, nil
+}
+
+// lookup returns the entry for name, following links within the archive.
+func (ti *tarIndex) lookup(name string) (tarEntry, error) {
+	name = tarName(name)
+	for i := 0; i < 16; i++ {
+		entry, ok := ti.entries[name]
+		if !ok {
+			return tarEntry{}, fmt.Errorf("File '%s' in archive %q %w", name, ti.path, ErrNotFound)
+		}
+		if entry.link == "" {
+			return entry, nil
+		}
+		name = entry.link
+	}
+	return tarEntry{}, fmt.Errorf("Too many levels of links for '%s' in archive %q", name, ti.path)
+}
+
+func (ti *tarIndex) Has(name string) bool {
+	_, err := ti.lookup(name)
+	return err == nil
+}
+
+type tarFileReader struct {
+	*io.SectionReader
+	fh *os.File
+}
+
+func (tfr *tarFileReader) Close() error {
+	return tfr.fh.Close()
+}
+
+func (ti *tarIndex) Open(name string) (io.ReadCloser, error) {
+	entry, err := ti.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := os.Open(ti.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open archive %q: %s", ti.path, err)
+	}
+	return &tarFileReader{SectionReader: io.NewSectionReader(fh, entry.offset, entry.size), fh: fh}, nil
+}
+
+func (ti *tarIndex) ReadFile(name string) ([]byte, error) {
+	reader, err := ti.Open(name)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return []byte{}, fmt.Errorf("Failed to read '%s' from archive %q: %s", name, ti.path, err)
+	}
+	return content, nil
+}
+
+// tarFile is a new archive member for appendTarFiles.
+type tarFile struct {
+	name    string
+	content []byte
+}
+
+// appendTarFiles writes files over the end-of-archive marker of the tar at
+// tarPath, creating it if needed, and returns the updated index.  The
+// archive is only indexed again if it changed since cached.
+func appendTarFiles(tarPath string, cached *tarIndex, files []tarFile) (*tarIndex, error) {
+	tarLock.Lock()
+	defer tarLock.Unlock()
+
+	ti := &tarIndex{path: tarPath, entries: map[string]tarEntry{}}
+	if _, err := os.Stat(tarPath); err == nil {
+		if !cached.current() {
+			if cached, err = indexTar(tarPath); err != nil {
+				return nil, err
+			}
+		}
+		// readers may still hold cached, add to a copy
+		for name, entry := range cached.entries {
+			ti.entries[name] = entry
+		}
+		ti.end = cached.end
+	}
+
+	fh, err := os.OpenFile(tarPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open archive %q for writing: %s", tarPath, err)
+	}
+	defer fh.Close()
+
+	if _, err := fh.Seek(ti.end, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("Failed to seek to end of archive %q: %s", tarPath, err)
+	}
+
+	tw := tar.NewWriter(fh)
+	now := time.Now()
+	for _, file := range files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.content)),
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("Failed to write '%s' header to archive %q: %s", file.name, tarPath, err)
+		}
+		offset, err := fh.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(tw, bytes.NewReader(file.content)); err != nil {
+			return nil, fmt.Errorf("Failed to write '%s' to archive %q: %s", file.name, tarPath, err)
+		}
+		ti.entries[tarName(file.name)] = tarEntry{offset: offset, size: hdr.Size}
+	}
+	// pad the last entry, the end-of-archive marker goes after it
+	if err := tw.Flush(); err != nil {
+		return nil, fmt.Errorf("Failed to write archive %q: %s", tarPath, err)
+	}
+	if ti.end, err = fh.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to finish archive %q: %s", tarPath, err)
+	}
+
+	// drop any old trailer or record padding past the new end of archive
+	pos, err := fh.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if err := fh.Truncate(pos); err != nil {
+		return nil, fmt.Errorf("Failed to truncate archive %q: %s", tarPath, err)
+	}
+	if err := ti.stamp(fh); err != nil {
+		return nil, err
+	}
+	if err := fh.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to close archive %q: %s", tarPath, err)
+	}
+	return ti, nil
+}
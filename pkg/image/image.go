@@ -5,16 +5,21 @@ import (
 	"context"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/containers/image/v5/copy"
 	"github.com/containers/image/v5/docker"
+	dockerarchive "github.com/containers/image/v5/docker/archive"
 	"github.com/containers/image/v5/docker/daemon"
+	ociarchive "github.com/containers/image/v5/oci/archive"
 	"github.com/containers/image/v5/oci/layout"
-	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/pkg/compression"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/umoci"
 	"github.com/pkg/errors"
+	"github.com/raharper/ocidist/pkg/progress"
 )
 
 var urlSchemes map[string]func(string) (types.ImageReference, error)
@@ -30,9 +35,11 @@ func init() {
 	// deps or not.
 	urlSchemes = map[string]func(string) (types.ImageReference, error){}
 	RegisterURLScheme("oci", layout.ParseReference)
+	RegisterURLScheme("oci-archive", ociarchive.ParseReference)
 	RegisterURLScheme("ocidist", docker.ParseReference)
 	RegisterURLScheme("docker", docker.ParseReference)
 	RegisterURLScheme("docker-daemon", daemon.ParseReference)
+	RegisterURLScheme("docker-archive", dockerarchive.ParseReference)
 }
 
 func localRefParser(ref string) (types.ImageReference, error) {
@@ -61,13 +68,54 @@ type ImageCopyOpts struct {
 	DestSkipTLS       bool
 	Progress          io.Writer
 	Context           context.Context
+	// copy every image of a manifest list, or only these platforms
+	All       bool
+	Platforms []ispec.Platform
+	// concurrent layer downloads, the containers/image default if unset
+	Jobs int
+	// containers-policy.json to enforce, any source is accepted if unset
+	PolicyPath string
+	// recompress layers as "gzip" or "zstd", containers/image cannot write
+	// uncompressed layers to registries or layouts
+	DestCompression  string
+	CompressionLevel *int
+	// fail rather than change any manifest digest
+	PreserveDigests bool
+	// receives blob events as containers/image copies, then the pushed
+	// manifest and done or error
+	Reporter progress.Reporter
 }
 
-func ImageCopy(opts ImageCopyOpts) error {
+func sourceContext(opts ImageCopyOpts) *types.SystemContext {
+	sysCtx := &types.SystemContext{
+		OCIAcceptUncompressedLayers: true,
+	}
+
+	if opts.SrcSkipTLS {
+		sysCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+		sysCtx.DockerDaemonInsecureSkipTLSVerify = true
+	}
+
+	if opts.SrcUsername != "" {
+		sysCtx.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: opts.SrcUsername,
+			Password: opts.SrcPassword,
+		}
+	}
+	return sysCtx
+}
+
+func ImageCopy(opts ImageCopyOpts) (err error) {
 	if opts.Context == nil {
 		opts.Context = context.Background()
 	}
 
+	defer func() {
+		if err != nil {
+			progress.Send(opts.Reporter, progress.Event{Type: progress.Error, Source: opts.Src, Dest: opts.Dest, Error: err.Error()})
+		}
+	}()
+
 	srcRef, err := localRefParser(opts.Src)
 	if err != nil {
 		return err
@@ -78,33 +126,23 @@ func ImageCopy(opts ImageCopyOpts) error {
 		return err
 	}
 
-	policy, err := signature.NewPolicyContext(&signature.Policy{
-		Default: []signature.PolicyRequirement{
-			signature.NewPRInsecureAcceptAnything(),
-		},
-	})
+	policy, err := policyContext(opts.PolicyPath)
 	if err != nil {
 		return err
 	}
+	defer policy.Destroy()
 
 	args := &copy.Options{
 		ReportWriter:     opts.Progress,
 		RemoveSignatures: true,
+		PreserveDigests:  opts.PreserveDigests,
 	}
 
-	args.SourceCtx = &types.SystemContext{}
-
-	if opts.SrcSkipTLS {
-		args.SourceCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
-		args.SourceCtx.DockerDaemonInsecureSkipTLSVerify = true
+	if opts.Jobs > 0 {
+		args.MaxParallelDownloads = uint(opts.Jobs)
 	}
 
-	if opts.SrcUsername != "" {
-		args.SourceCtx.DockerAuthConfig = &types.DockerAuthConfig{
-			Username: opts.SrcUsername,
-			Password: opts.SrcPassword,
-		}
-	}
+	args.SourceCtx = sourceContext(opts)
 
 	args.DestinationCtx = &types.SystemContext{}
 
@@ -120,8 +158,37 @@ func ImageCopy(opts ImageCopyOpts) error {
 		}
 	}
 
-	args.SourceCtx.OCIAcceptUncompressedLayers = true
-	args.DestinationCtx.OCIAcceptUncompressedLayers = true
+	// an OCI layout accepting uncompressed layers never recompresses them
+	args.DestinationCtx.OCIAcceptUncompressedLayers = opts.DestCompression == ""
+
+	if opts.DestCompression != "" {
+		if opts.DestCompression == "none" {
+			return errors.Errorf("containers/image cannot decompress layers for %s", transportName(destRef))
+		}
+		algo, err := compression.AlgorithmByName(opts.DestCompression)
+		if err != nil {
+			return errors.Errorf("Unsupported compression '%s', must be gzip, zstd or none", opts.DestCompression)
+		}
+		args.DestinationCtx.CompressionFormat = &algo
+		args.DestinationCtx.CompressionLevel = opts.CompressionLevel
+
+		// docker manifests have no zstd layer media type
+		if algo.Name() == compression.Zstd.Name() && opts.ForceManifestType == "" {
+			opts.ForceManifestType = ispec.MediaTypeImageManifest
+		}
+
+		// containers/image leaves layers as-is rather than fail when
+		// preserving digests
+		if opts.PreserveDigests {
+			recompress, err := layersNeedRecompression(opts.Context, srcRef, args.SourceCtx, algo.Name())
+			if err != nil {
+				return err
+			}
+			if recompress {
+				return errors.Errorf("Recompressing %s as %s would change its digest", transportName(srcRef), algo.Name())
+			}
+		}
+	}
 
 	// Set ForceManifestMIMEType
 	// Supported manifest type :- https://github.com/containers/image/blob/master/manifest/manifest.go#L49
@@ -130,10 +197,45 @@ func ImageCopy(opts ImageCopyOpts) error {
 		args.ForceManifestMIMEType = opts.ForceManifestType
 	}
 
-	_, err = copy.Image(opts.Context, policy, destRef, srcRef, args)
+	// containers/image keeps the source manifest list as-is and only copies
+	// the selected instances
+	if opts.All {
+		args.ImageListSelection = copy.CopyAllImages
+	} else if len(opts.Platforms) > 0 {
+		instances, err := platformInstances(opts.Context, srcRef, args.SourceCtx, opts.Platforms)
+		if err != nil {
+			return err
+		}
+		if instances != nil {
+			args.ImageListSelection = copy.CopySpecificImages
+			args.Instances = instances
+		}
+	}
+
+	var events sync.WaitGroup
+	var progressChan chan types.ProgressProperties
+	if opts.Reporter != nil {
+		progressChan = make(chan types.ProgressProperties)
+		args.Progress = progressChan
+		args.ProgressInterval = progressInterval
+		events.Add(1)
+		go func() {
+			defer events.Done()
+			reportProgress(opts.Reporter, progressChan)
+		}()
+	}
+
+	manifest, err := copy.Image(opts.Context, policy, destRef, srcRef, args)
+	// every blob event is reported before the manifest
+	if progressChan != nil {
+		close(progressChan)
+		events.Wait()
+	}
 	if err != nil {
-		return err
+		return policyError(err, srcRef, opts.PolicyPath)
 	}
+	copied := progress.Event{Type: progress.ManifestPushed, Digest: digest.FromBytes(manifest), Size: int64(len(manifest)), Ref: opts.Dest}
+	progress.Send(opts.Reporter, copied)
 
 	// containers/image OCI as of
 	// https://github.com/containers/image/commit/ca5fe04cb38a1f0e0b960e9388a3c6372efd215a
@@ -186,5 +288,7 @@ func ImageCopy(opts ImageCopyOpts) error {
 		}
 	}
 
+	copied.Type, copied.Ref, copied.Source, copied.Dest = progress.Done, "", opts.Src, opts.Dest
+	progress.Send(opts.Reporter, copied)
 	return nil
 }
@@ -0,0 +1,135 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ParsePlatforms parses a comma separated list of os/arch[/variant]
+func ParsePlatforms(platforms string) ([]ispec.Platform, error) {
+	parsed := []ispec.Platform{}
+	for _, platform := range strings.Split(platforms, ",") {
+		platform = strings.TrimSpace(platform)
+		if platform == "" {
+			continue
+		}
+
+		toks := strings.Split(platform, "/")
+		if len(toks) < 2 || len(toks) > 3 || toks[0] == "" || toks[1] == "" {
+			return nil, fmt.Errorf("Invalid platform '%s', must be os/arch[/variant]", platform)
+		}
+
+		p := ispec.Platform{OS: toks[0], Architecture: toks[1]}
+		if len(toks) == 3 {
+			p.Variant = toks[2]
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// PlatformMatches reports if have satisfies want, an empty variant in want
+// matches any variant.
+func PlatformMatches(want ispec.Platform, have *ispec.Platform) bool {
+	if have == nil {
+		return false
+	}
+	if want.OS != have.OS || want.Architecture != have.Architecture {
+		return false
+	}
+	return want.Variant == "" || want.Variant == have.Variant
+}
+
+// platformInstances returns the digest of the manifest list instance for each
+// platform, or nil if the source is not a manifest list.
+func platformInstances(ctx context.Context, srcRef types.ImageReference, sysCtx *types.SystemContext, platforms []ispec.Platform) ([]digest.Digest, error) {
+	src, err := srcRef.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	raw, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil, nil
+	}
+
+	list, err := manifest.ListFromBlob(raw, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []digest.Digest{}
+	for _, platform := range platforms {
+		choice := *sysCtx
+		choice.OSChoice = platform.OS
+		choice.ArchitectureChoice = platform.Architecture
+		choice.VariantChoice = platform.Variant
+
+		instance, err := list.ChooseInstance(&choice)
+		if err != nil {
+			return nil, fmt.Errorf("No image for platform %s/%s in %s: %s", platform.OS, platform.Architecture, transportName(srcRef), err)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func transportName(ref types.ImageReference) string {
+	return fmt.Sprintf("%s:%s", ref.Transport().Name(), ref.StringWithinTransport())
+}
+
+// layersNeedRecompression reports if any layer of the source, or of every
+// image of a source manifest list, is not already compressed with algorithm.
+func layersNeedRecompression(ctx context.Context, srcRef types.ImageReference, sysCtx *types.SystemContext, algorithm string) (bool, error) {
+	src, err := srcRef.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	raw, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	manifests := [][]byte{raw}
+	mimeTypes := []string{mimeType}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(raw, mimeType)
+		if err != nil {
+			return false, err
+		}
+		manifests, mimeTypes = nil, nil
+		for _, instance := range list.Instances() {
+			raw, mimeType, err := src.GetManifest(ctx, &instance)
+			if err != nil {
+				return false, err
+			}
+			manifests = append(manifests, raw)
+			mimeTypes = append(mimeTypes, mimeType)
+		}
+	}
+
+	for i := range manifests {
+		m, err := manifest.FromBlob(manifests[i], mimeTypes[i])
+		if err != nil {
+			return false, err
+		}
+		for _, layer := range m.LayerInfos() {
+			if !strings.HasSuffix(layer.MediaType, algorithm) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// policyContext returns the containers-policy.json at path, or a policy
+// accepting anything if path is empty.
+func policyContext(path string) (*signature.PolicyContext, error) {
+	policy := &signature.Policy{
+		Default: []signature.PolicyRequirement{
+			signature.NewPRInsecureAcceptAnything(),
+		},
+	}
+
+	if path != "" {
+		var err error
+		policy, err = signature.NewPolicyFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load signature policy %q: %s", path, err)
+		}
+	}
+	return signature.NewPolicyContext(policy)
+}
+
+// policyError explains a rejection by the signature policy, other errors are
+// returned as they are.
+func policyError(err error, srcRef types.ImageReference, path string) error {
+	var rejected signature.PolicyRequirementError
+	if errors.As(err, &rejected) {
+		return fmt.Errorf("Source %s rejected by signature policy %q: %s", transportName(srcRef), path, rejected)
+	}
+	return err
+}
+
+// CheckPolicy evaluates the signature policy against the source image the
+// way ImageCopy does, for copies that do not go through containers/image.
+// If dgst is set the image checked must be the manifest at dgst.
+func CheckPolicy(opts ImageCopyOpts, dgst digest.Digest) error {
+	if opts.PolicyPath == "" {
+		return nil
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+
+	srcRef, err := localRefParser(opts.Src)
+	if err != nil {
+		return err
+	}
+
+	policy, err := policyContext(opts.PolicyPath)
+	if err != nil {
+		return err
+	}
+	defer policy.Destroy()
+
+	src, err := srcRef.NewImageSource(opts.Context, sourceContext(opts))
+	if err != nil {
+		return fmt.Errorf("Failed to open %s: %s", transportName(srcRef), err)
+	}
+	defer src.Close()
+
+	unparsed := image.UnparsedInstance(src, nil)
+	allowed, err := policy.IsRunningImageAllowed(opts.Context, unparsed)
+	if !allowed {
+		if err == nil {
+			err = signature.PolicyRequirementError("policy rejected the image")
+		}
+		return policyError(err, srcRef, opts.PolicyPath)
+	}
+
+	if dgst != "" {
+		content, _, err := unparsed.Manifest(opts.Context)
+		if err != nil {
+			return fmt.Errorf("Failed to read manifest of %s: %s", transportName(srcRef), err)
+		}
+		if checked := digest.FromBytes(content); checked != dgst {
+			return fmt.Errorf("Source %s changed to '%s' while checking signature policy for '%s'", transportName(srcRef), checked, dgst)
+		}
+	}
+	return nil
+}
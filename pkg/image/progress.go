@@ -0,0 +1,37 @@
+package image
+
+import (
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/raharper/ocidist/pkg/progress"
+)
+
+// how often containers/image reports bytes read of each blob
+const progressInterval = 500 * time.Millisecond
+
+// reportProgress sends the events of containers/image's progress channel to
+// reporter until the channel is closed.
+func reportProgress(reporter progress.Reporter, progressChan <-chan types.ProgressProperties) {
+	for props := range progressChan {
+		ev := progress.Event{
+			Digest:    props.Artifact.Digest,
+			MediaType: props.Artifact.MediaType,
+			Size:      props.Artifact.Size,
+			Offset:    int64(props.Offset),
+		}
+		switch props.Event {
+		case types.ProgressEventNewArtifact:
+			ev.Type = progress.BlobStart
+		case types.ProgressEventRead:
+			ev.Type = progress.BlobProgress
+		case types.ProgressEventDone:
+			ev.Type = progress.BlobDone
+		case types.ProgressEventSkipped:
+			ev.Type = progress.BlobSkipped
+		default:
+			continue
+		}
+		progress.Send(reporter, ev)
+	}
+}
@@ -0,0 +1,365 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+	Shared   = "shared"
+)
+
+type ConfigChange struct {
+	Field string `json:"field"`
+	// the variable, label or history entry of the field that changed
+	Key    string `json:"key,omitempty"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+type LayerChange struct {
+	Digest digest.Digest `json:"digest"`
+	// the uncompressed digest layers are matched by
+	DiffID digest.Digest `json:"diffID,omitempty"`
+	Size   int64         `json:"size"`
+	Change string        `json:"change"`
+}
+
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	// of the file in the second image, or the first when removed
+	Mode     string `json:"mode"`
+	Size     int64  `json:"size"`
+	FromMode string `json:"fromMode,omitempty"`
+	FromSize int64  `json:"fromSize,omitempty"`
+}
+
+// Diff is what changed from one image to another
+type Diff struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	FromDigest digest.Digest  `json:"fromDigest"`
+	ToDigest   digest.Digest  `json:"toDigest"`
+	Config     []ConfigChange `json:"config"`
+	Layers     []LayerChange  `json:"layers"`
+	// only when the filesystems were compared
+	Files []FileChange `json:"files,omitempty"`
+}
+
+// DiffImages compares the configs and layers of two images and, if files,
+// their merged filesystems.
+func DiffImages(from, to *Image, files bool) (*Diff, error) {
+	diff := &Diff{
+		From:       from.API.SourceURL(),
+		To:         to.API.SourceURL(),
+		FromDigest: from.Digest,
+		ToDigest:   to.Digest,
+		Config:     DiffConfig(&from.Config, &to.Config),
+		Layers:     DiffLayers(from, to),
+	}
+
+	if !files {
+		return diff, nil
+	}
+	fromTree, err := from.Merge(true)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Merge(true)
+	if err != nil {
+		return nil, err
+	}
+	diff.Files = DiffTrees(fromTree, toTree)
+	return diff, nil
+}
+
+// DiffConfig returns the changes of the runtime config and history
+func DiffConfig(from, to *ispec.Image) []ConfigChange {
+	changes := []ConfigChange{}
+	changes = append(changes, diffValue("Architecture", from.Architecture, to.Architecture)...)
+	changes = append(changes, diffValue("Os", from.OS, to.OS)...)
+	changes = append(changes, diffMap("Env", envMap(from.Config.Env), envMap(to.Config.Env))...)
+	changes = append(changes, diffMap("Labels", from.Config.Labels, to.Config.Labels)...)
+	changes = append(changes, diffValue("Entrypoint", strings.Join(from.Config.Entrypoint, " "), strings.Join(to.Config.Entrypoint, " "))...)
+	changes = append(changes, diffValue("Cmd", strings.Join(from.Config.Cmd, " "), strings.Join(to.Config.Cmd, " "))...)
+	changes = append(changes, diffValue("WorkingDir", from.Config.WorkingDir, to.Config.WorkingDir)...)
+	changes = append(changes, diffValue("User", from.Config.User, to.Config.User)...)
+	changes = append(changes, diffValue("StopSignal", from.Config.StopSignal, to.Config.StopSignal)...)
+	changes = append(changes, diffMap("ExposedPorts", setMap(from.Config.ExposedPorts), setMap(to.Config.ExposedPorts))...)
+	changes = append(changes, diffMap("Volumes", setMap(from.Config.Volumes), setMap(to.Config.Volumes))...)
+	return append(changes, diffHistory(from.History, to.History)...)
+}
+
+func diffValue(field, from, to string) []ConfigChange {
+	switch {
+	case from == to:
+		return nil
+	case from == "":
+		return []ConfigChange{{Field: field, Change: Added, To: to}}
+	case to == "":
+		return []ConfigChange{{Field: field, Change: Removed, From: from}}
+	}
+	return []ConfigChange{{Field: field, Change: Modified, From: from, To: to}}
+}
+
+func diffMap(field string, from, to map[string]string) []ConfigChange {
+	keys := map[string]bool{}
+	for key := range from {
+		keys[key] = true
+	}
+	for key := range to {
+		keys[key] = true
+	}
+
+	changes := []ConfigChange{}
+	for key := range keys {
+		fromValue, inFrom := from[key]
+		toValue, inTo := to[key]
+		switch {
+		case !inFrom:
+			changes = append(changes, ConfigChange{Field: field, Key: key, Change: Added, To: toValue})
+		case !inTo:
+			changes = append(changes, ConfigChange{Field: field, Key: key, Change: Removed, From: fromValue})
+		case fromValue != toValue:
+			changes = append(changes, ConfigChange{Field: field, Key: key, Change: Modified, From: fromValue, To: toValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// diffHistory reports the entries after the history both images start with
+func diffHistory(from, to []ispec.History) []ConfigChange {
+	common := 0
+	for common < len(from) && common < len(to) && historyLine(from[common]) == historyLine(to[common]) {
+		common++
+	}
+
+	changes := []ConfigChange{}
+	for i := common; i < len(from); i++ {
+		changes = append(changes, ConfigChange{Field: "History", Key: fmt.Sprint(i), Change: Removed, From: historyLine(from[i])})
+	}
+	for i := common; i < len(to); i++ {
+		changes = append(changes, ConfigChange{Field: "History", Key: fmt.Sprint(i), Change: Added, To: historyLine(to[i])})
+	}
+	return changes
+}
+
+func historyLine(h ispec.History) string {
+	if h.Comment != "" {
+		return fmt.Sprintf("%s (%s)", h.CreatedBy, h.Comment)
+	}
+	return h.CreatedBy
+}
+
+func envMap(env []string) map[string]string {
+	vars := map[string]string{}
+	for _, variable := range env {
+		name, value, _ := strings.Cut(variable, "=")
+		vars[name] = value
+	}
+	return vars
+}
+
+func setMap(set map[string]struct{}) map[string]string {
+	values := map[string]string{}
+	for key := range set {
+		values[key] = ""
+	}
+	return values
+}
+
+// DiffLayers matches layers by their uncompressed digest, so recompressed
+// layers are still shared, or by digest without a diff_id for each layer.
+func DiffLayers(from, to *Image) []LayerChange {
+	fromKeys := layerKeys(from)
+	toKeys := layerKeys(to)
+
+	inFrom := map[digest.Digest]bool{}
+	for _, key := range fromKeys {
+		inFrom[key] = true
+	}
+	inTo := map[digest.Digest]bool{}
+	for _, key := range toKeys {
+		inTo[key] = true
+	}
+
+	changes := []LayerChange{}
+	for n := range from.Manifest.Layers {
+		if !inTo[fromKeys[n]] {
+			changes = append(changes, layerChange(from, n, Removed))
+		}
+	}
+	for n := range to.Manifest.Layers {
+		change := Added
+		if inFrom[toKeys[n]] {
+			change = Shared
+		}
+		changes = append(changes, layerChange(to, n, change))
+	}
+	return changes
+}
+
+func layerKeys(img *Image) []digest.Digest {
+	keys := []digest.Digest{}
+	useDiffIDs := len(img.Config.RootFS.DiffIDs) == len(img.Manifest.Layers)
+	for n, layer := range img.Manifest.Layers {
+		if useDiffIDs {
+			keys = append(keys, img.Config.RootFS.DiffIDs[n])
+		} else {
+			keys = append(keys, layer.Digest)
+		}
+	}
+	return keys
+}
+
+func layerChange(img *Image, n int, change string) LayerChange {
+	layer := img.Manifest.Layers[n]
+	lc := LayerChange{Digest: layer.Digest, Size: layer.Size, Change: change}
+	if len(img.Config.RootFS.DiffIDs) == len(img.Manifest.Layers) {
+		lc.DiffID = img.Config.RootFS.DiffIDs[n]
+	}
+	return lc
+}
+
+// DiffTrees compares two merged filesystems, files being modified if their
+// type, mode, owner, size, link or content differ.
+func DiffTrees(from, to *Tree) []FileChange {
+	changes := []FileChange{}
+	for _, entry := range from.Entries() {
+		if _, ok := to.Get(entry.Path); !ok {
+			changes = append(changes, FileChange{
+				Path:   entry.Path,
+				Change: Removed,
+				Mode:   Mode(entry.Header),
+				Size:   entry.Header.Size,
+			})
+		}
+	}
+	for _, entry := range to.Entries() {
+		fromEntry, ok := from.Get(entry.Path)
+		if !ok {
+			changes = append(changes, FileChange{
+				Path:   entry.Path,
+				Change: Added,
+				Mode:   Mode(entry.Header),
+				Size:   entry.Header.Size,
+			})
+			continue
+		}
+		if entryChanged(fromEntry, entry) {
+			changes = append(changes, FileChange{
+				Path:     entry.Path,
+				Change:   Modified,
+				Mode:     Mode(entry.Header),
+				Size:     entry.Header.Size,
+				FromMode: Mode(fromEntry.Header),
+				FromSize: fromEntry.Header.Size,
+			})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func entryChanged(from, to *Entry) bool {
+	a, b := from.Header, to.Header
+	return a.Typeflag != b.Typeflag || a.Mode != b.Mode || a.Uid != b.Uid || a.Gid != b.Gid ||
+		a.Size != b.Size || a.Linkname != b.Linkname || from.Digest != to.Digest
+}
+
+// Mode returns the type and permissions of an entry as ls prints them
+func Mode(hdr *tar.Header) string {
+	kind := "-"
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		kind = "d"
+	case tar.TypeSymlink:
+		kind = "l"
+	case tar.TypeChar:
+		kind = "c"
+	case tar.TypeBlock:
+		kind = "b"
+	case tar.TypeFifo:
+		kind = "p"
+	}
+
+	perm := []byte(os.FileMode(hdr.Mode).Perm().String()[1:])
+	special := []struct {
+		bit  int64
+		idx  int
+		mark byte
+	}{{04000, 2, 's'}, {02000, 5, 's'}, {01000, 8, 't'}}
+	for _, s := range special {
+		if hdr.Mode&s.bit != 0 {
+			if perm[s.idx] == 'x' {
+				perm[s.idx] = s.mark
+			} else {
+				perm[s.idx] = s.mark - 'a' + 'A'
+			}
+		}
+	}
+	return kind + string(perm)
+}
+
+var changeMarks = map[string]string{
+	Added:    "+",
+	Removed:  "-",
+	Modified: "~",
+	Shared:   "=",
+}
+
+// Print writes the diff for people to read
+func (d *Diff) Print(w io.Writer) {
+	fmt.Fprintf(w, "Diff %s %s\n  to %s %s\n", d.From, d.FromDigest, d.To, d.ToDigest)
+
+	fmt.Fprintln(w, "Config:")
+	for _, c := range d.Config {
+		name := c.Field
+		if c.Key != "" {
+			name = fmt.Sprintf("%s %s", c.Field, c.Key)
+		}
+		switch c.Change {
+		case Added:
+			fmt.Fprintf(w, "  + %s: %s\n", name, c.To)
+		case Removed:
+			fmt.Fprintf(w, "  - %s: %s\n", name, c.From)
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", name, c.From, c.To)
+		}
+	}
+
+	fmt.Fprintln(w, "Layers:")
+	for _, l := range d.Layers {
+		fmt.Fprintf(w, "  %s %s %s\n", changeMarks[l.Change], l.Digest, units.BytesSize(float64(l.Size)))
+	}
+
+	if d.Files == nil {
+		return
+	}
+	fmt.Fprintln(w, "Files:")
+	for _, f := range d.Files {
+		fmt.Fprintf(w, "  %s %s %s %s", changeMarks[f.Change], f.Mode, units.BytesSize(float64(f.Size)), f.Path)
+		if f.Change == Modified {
+			fmt.Fprintf(w, " (was %s %s)", f.FromMode, units.BytesSize(float64(f.FromSize)))
+		}
+		fmt.Fprintln(w)
+	}
+}
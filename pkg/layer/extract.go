@@ -0,0 +1,203 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type extractedDir struct {
+	target string
+	hdr    *tar.Header
+}
+
+type deferredLink struct {
+	target string
+	entry  *Entry
+}
+
+// Extract writes the file or directory tree at name in the merged view to
+// dest, reading only the layers that can hold part of it.  A file is written
+// into dest if dest is a directory.  Entries are written beneath dest only,
+// never through symlinks, and without their owners.
+func (img *Image) Extract(name, dest string) error {
+	root, err := img.Lookup(name, false)
+	if err != nil {
+		return err
+	}
+
+	if root.Header.Typeflag != tar.TypeDir {
+		if info, err := os.Stat(dest); err == nil && info.IsDir() {
+			dest = filepath.Join(dest, path.Base(root.Path))
+		}
+	} else if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("Failed to create '%s': %s", dest, err)
+	}
+
+	dirs := []extractedDir{}
+	links := []deferredLink{}
+	// the layers of the regular files written
+	written := map[string]int{}
+	err = img.walkMerged(root.Path, len(img.Manifest.Layers)-1, func(entry *Entry, r io.Reader) error {
+		if !isUnder(entry.Path, root.Path) {
+			return nil
+		}
+
+		target, err := safeTarget(dest, strings.TrimPrefix(entry.Path, root.Path))
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"path":   entry.Path,
+			"target": target,
+			"layer":  entry.Layer,
+		}).Debug("Image.Extract() extracting entry")
+
+		hdr := entry.Header
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return fmt.Errorf("Failed to create '%s': %s", target, err)
+			}
+			dirs = append(dirs, extractedDir{target: target, hdr: hdr})
+			return nil
+		case tar.TypeReg:
+			if err := mkdirParent(target); err != nil {
+				return err
+			}
+			written[entry.Path] = entry.Layer
+			return writeFile(target, hdr, func(w io.Writer) error {
+				_, err := io.Copy(w, r)
+				return err
+			})
+		case tar.TypeSymlink:
+			if err := mkdirParent(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("Failed to create symlink '%s': %s", target, err)
+			}
+			return nil
+		case tar.TypeLink:
+			// the target may be in a lower layer, not yet written
+			links = append(links, deferredLink{target: target, entry: entry})
+			return nil
+		default:
+			log.Debugf("Image.Extract() skipping special file '%s'", entry.Path)
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if err := img.extractLink(root.Path, dest, link, written); err != nil {
+			return err
+		}
+	}
+
+	// once everything is written in them, deepest first, as a directory's
+	// entries may be in a higher layer than the directory
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i].target, string(filepath.Separator)) >
+			strings.Count(dirs[j].target, string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		if err := os.Chmod(dir.target, os.FileMode(dir.hdr.Mode).Perm()); err != nil {
+			return fmt.Errorf("Failed to set mode of '%s': %s", dir.target, err)
+		}
+		os.Chtimes(dir.target, dir.hdr.ModTime, dir.hdr.ModTime)
+	}
+	return nil
+}
+
+// extractLink links to the target when it was extracted as well, or writes
+// a copy of the target's content.  The extracted target is the link's only if
+// no layer above the link's replaced it.
+func (img *Image) extractLink(root, dest string, link deferredLink, written map[string]int) error {
+	// a symlink written since may be in the way
+	target, err := safeTarget(dest, strings.TrimPrefix(link.entry.Path, root))
+	if err != nil {
+		return err
+	}
+	if err := mkdirParent(target); err != nil {
+		return err
+	}
+
+	linkname := link.entry.Header.Linkname
+	if n, ok := written[linkname]; ok && n <= link.entry.Layer {
+		if source, err := safeTarget(dest, strings.TrimPrefix(linkname, root)); err == nil {
+			if info, err := os.Lstat(source); err == nil && info.Mode().IsRegular() {
+				if err := os.Link(source, link.target); err != nil {
+					return fmt.Errorf("Failed to create hardlink '%s': %s", link.target, err)
+				}
+				return nil
+			}
+		}
+	}
+
+	entry, err := img.resolveHardlink(link.entry)
+	if err != nil {
+		return err
+	}
+	return writeFile(link.target, link.entry.Header, func(w io.Writer) error {
+		return img.copyContent(entry, w)
+	})
+}
+
+// safeTarget joins the entry path rel to dest, refusing to go through a
+// symlink or to replace one.
+func safeTarget(dest, rel string) (string, error) {
+	target := dest
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+rel), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if part == ".." {
+			return "", fmt.Errorf("Refusing to extract '%s' outside of '%s'", rel, dest)
+		}
+		target = filepath.Join(target, part)
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("Refusing to extract '%s' through symlink '%s'", rel, target)
+		}
+	}
+	return target, nil
+}
+
+// mkdirParent creates the directories above target that are not extracted
+// yet, private until their own entries set their modes.
+func mkdirParent(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return fmt.Errorf("Failed to create '%s': %s", filepath.Dir(target), err)
+	}
+	return nil
+}
+
+// writeFile creates target, with the content written by write, and the mode
+// and times of hdr.
+func writeFile(target string, hdr *tar.Header, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to create '%s': %s", target, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write '%s': %s", target, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to write '%s': %s", target, err)
+	}
+	if err := os.Chmod(target, os.FileMode(hdr.Mode).Perm()); err != nil {
+		return fmt.Errorf("Failed to set mode of '%s': %s", target, err)
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
@@ -0,0 +1,62 @@
+package layer
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractNestedDirsAcrossLayers(t *testing.T) {
+	img := openTestImage(t,
+		[]testEntry{
+			{name: "bin/", typeflag: tar.TypeDir, mode: 0755},
+			{name: "bin/sh", typeflag: tar.TypeReg, content: "sh", mode: 0755},
+			{name: "opt/", typeflag: tar.TypeDir, mode: 0755},
+			{name: "opt/app/", typeflag: tar.TypeDir, mode: 0750},
+			{name: "opt/app/a.txt", typeflag: tar.TypeReg, content: "a", mode: 0644},
+		},
+		// files of directories only the lower layer holds
+		[]testEntry{
+			{name: "opt/app/c.txt", typeflag: tar.TypeReg, content: "c", mode: 0644},
+			{name: "opt/app/lib/d.txt", typeflag: tar.TypeReg, content: "d", mode: 0600},
+			{name: "opt/app/link", typeflag: tar.TypeSymlink, linkname: "c.txt", mode: 0777},
+			{name: "opt/app/sh", typeflag: tar.TypeLink, linkname: "bin/sh", mode: 0755},
+		},
+	)
+
+	dest := filepath.Join(t.TempDir(), "out")
+	if err := img.Extract("/opt", dest); err != nil {
+		t.Fatalf("Failed to extract /opt: %s", err)
+	}
+
+	for name, want := range map[string]string{
+		"app/a.txt":     "a",
+		"app/c.txt":     "c",
+		"app/lib/d.txt": "d",
+		"app/sh":        "sh",
+	} {
+		content, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Errorf("Failed to read %s: %s", name, err)
+		} else if string(content) != want {
+			t.Errorf("%s has %q, expected %q", name, content, want)
+		}
+	}
+
+	if linkname, err := os.Readlink(filepath.Join(dest, "app/link")); err != nil || linkname != "c.txt" {
+		t.Errorf("app/link is %q (%v), expected a symlink to c.txt", linkname, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "app"))
+	if err != nil {
+		t.Fatalf("Failed to stat app: %s", err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("app has mode %o, expected 750", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("app has mtime %s, expected the layer's", info.ModTime())
+	}
+}
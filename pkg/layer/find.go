@@ -0,0 +1,228 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// maxSymlinks is how many symlinks a lookup follows, as in Linux
+const maxSymlinks = 40
+
+// mergedWalk is the state of a walk from the top layer down, what upper
+// layers hide from the ones below.
+type mergedWalk struct {
+	// the visible entries and whether they are directories
+	seen   map[string]bool
+	hidden []string
+	opaque []string
+}
+
+func (mw *mergedWalk) visible(name string) bool {
+	if _, ok := mw.seen[name]; ok {
+		return false
+	}
+	for _, h := range mw.hidden {
+		if isUnder(name, h) {
+			return false
+		}
+	}
+	for _, dir := range mw.opaque {
+		if name != dir && isUnder(name, dir) {
+			return false
+		}
+	}
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if isDir, ok := mw.seen[dir]; ok && !isDir {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkMerged calls fn with the visible entries of the merged view under name,
+// and name's visible parent directories, from the top layer down.  Layers
+// are read only until the ones below can hold nothing visible under name.  fn
+// returning fs.SkipAll ends the walk.
+func (img *Image) WalkMerged(name string, fn func(entry *Entry, r io.Reader) error) error {
+	return img.walkMerged(CleanPath(name), len(img.Manifest.Layers)-1, fn)
+}
+
+// walkMerged walks the merged view of the layers up to top
+func (img *Image) walkMerged(name string, top int, fn func(entry *Entry, r io.Reader) error) error {
+	mw := &mergedWalk{seen: map[string]bool{}}
+	for n := top; n >= 0; n-- {
+		hidden := []string{}
+		opaque := []string{}
+		last := false
+
+		err := img.WalkLayer(n, func(p string, hdr *tar.Header, r io.Reader) error {
+			if target, isOpaque, ok := whiteout(p); ok {
+				if !isUnder(target, name) && !isUnder(name, target) {
+					return nil
+				}
+				if isOpaque {
+					opaque = append(opaque, target)
+				} else {
+					hidden = append(hidden, target)
+				}
+				// nothing lower is visible once name or a parent is hidden
+				if isUnder(name, target) {
+					last = true
+				}
+				return nil
+			}
+
+			if !isUnder(p, name) && !isUnder(name, p) {
+				return nil
+			}
+			if !mw.visible(p) {
+				return nil
+			}
+
+			isDir := hdr.Typeflag == tar.TypeDir
+			mw.seen[p] = isDir
+			if !isDir && isUnder(name, p) {
+				last = true
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = CleanPath(hdr.Linkname)
+			}
+			return fn(&Entry{Path: p, Header: hdr, Layer: n}, r)
+		})
+		if err == fs.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mw.hidden = append(mw.hidden, hidden...)
+		mw.opaque = append(mw.opaque, opaque...)
+		if last {
+			return nil
+		}
+	}
+	return nil
+}
+
+// symlinkTarget returns the absolute path a symlink entry points to
+func symlinkTarget(entry *Entry) string {
+	if path.IsAbs(entry.Header.Linkname) {
+		return CleanPath(entry.Header.Linkname)
+	}
+	return path.Join(path.Dir(entry.Path), entry.Header.Linkname)
+}
+
+// Lookup returns the entry at name in the merged view, following symlinks in
+// its parents and, if follow, name itself being a symlink.  A directory only
+// implied by the paths of its contents has a header made up for it.
+func (img *Image) Lookup(name string, follow bool) (*Entry, error) {
+	return img.lookup(CleanPath(name), len(img.Manifest.Layers)-1, follow)
+}
+
+func (img *Image) lookup(name string, top int, follow bool) (*Entry, error) {
+	for hops := 0; hops <= maxSymlinks; hops++ {
+		var found *Entry
+		implied := -1
+		redirect := ""
+
+		err := img.walkMerged(name, top, func(entry *Entry, r io.Reader) error {
+			switch {
+			case entry.Path == name:
+				found = entry
+				if follow && entry.Header.Typeflag == tar.TypeSymlink {
+					redirect = symlinkTarget(entry)
+				}
+				return fs.SkipAll
+			case isUnder(entry.Path, name):
+				if implied < 0 {
+					implied = entry.Layer
+				}
+			case entry.Header.Typeflag == tar.TypeSymlink:
+				redirect = path.Join(symlinkTarget(entry), strings.TrimPrefix(name, entry.Path))
+				return fs.SkipAll
+			case entry.Header.Typeflag != tar.TypeDir:
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if redirect != "" {
+			name = redirect
+			top = len(img.Manifest.Layers) - 1
+			continue
+		}
+		if found != nil {
+			return found, nil
+		}
+		if implied >= 0 {
+			hdr := &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}
+			return &Entry{Path: name, Header: hdr, Layer: implied}, nil
+		}
+		return nil, fmt.Errorf("'%s' does not exist in the image: %w", name, fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("Too many levels of symlinks looking up '%s'", name)
+}
+
+// ReadFile copies the content of the file at name, following symlinks and
+// hardlinks, to w and returns its entry.  Only the layers down to the one
+// holding the file are read.
+func (img *Image) ReadFile(name string, w io.Writer) (*Entry, error) {
+	entry, err := img.Lookup(name, true)
+	if err != nil {
+		return nil, err
+	}
+	if entry, err = img.resolveHardlink(entry); err != nil {
+		return nil, err
+	}
+	if err := img.copyContent(entry, w); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// resolveHardlink returns the regular file a hardlink shares the content of,
+// as the file was in the link's layer.
+func (img *Image) resolveHardlink(entry *Entry) (*Entry, error) {
+	link := entry
+	for hops := 0; entry.Header.Typeflag == tar.TypeLink; hops++ {
+		if hops > maxSymlinks {
+			return nil, fmt.Errorf("Too many levels of hardlinks resolving '%s'", link.Path)
+		}
+		var err error
+		if entry, err = img.lookup(entry.Header.Linkname, entry.Layer, false); err != nil {
+			return nil, err
+		}
+	}
+	if entry.Header.Typeflag != tar.TypeReg {
+		return nil, fmt.Errorf("'%s' is not a regular file", entry.Path)
+	}
+	return entry, nil
+}
+
+// copyContent copies a regular file's content from its layer to w
+func (img *Image) copyContent(entry *Entry, w io.Writer) error {
+	err := img.WalkLayer(entry.Layer, func(p string, hdr *tar.Header, r io.Reader) error {
+		if p != entry.Path || hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("Failed to copy '%s': %s", p, err)
+		}
+		return fs.SkipAll
+	})
+	if err == fs.SkipAll {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("'%s' is missing from layer '%s'", entry.Path, img.Manifest.Layers[entry.Layer].Digest)
+}
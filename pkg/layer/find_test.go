@@ -0,0 +1,60 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// countingAPI counts the blobs fetched from an OCIAPI
+type countingAPI struct {
+	api.OCIAPI
+	fetched map[digest.Digest]int
+}
+
+func (ca *countingAPI) GetBlob(desc *ispec.Descriptor) ([]byte, error) {
+	ca.fetched[desc.Digest]++
+	return ca.OCIAPI.GetBlob(desc)
+}
+
+func TestReadFileFetchesLayersOnce(t *testing.T) {
+	img := openTestImage(t,
+		[]testEntry{
+			{name: "usr/", typeflag: tar.TypeDir, mode: 0755},
+			{name: "usr/lib/", typeflag: tar.TypeDir, mode: 0755},
+			{name: "usr/lib/libc.so", typeflag: tar.TypeReg, content: "libc", mode: 0755},
+		},
+		[]testEntry{
+			{name: "lib", typeflag: tar.TypeSymlink, linkname: "usr/lib", mode: 0777},
+			{name: "usr/lib/libc.so.6", typeflag: tar.TypeSymlink, linkname: "libc.so", mode: 0777},
+		},
+		[]testEntry{
+			{name: "etc/hostname", typeflag: tar.TypeReg, content: "host", mode: 0644},
+		},
+	)
+	counter := &countingAPI{OCIAPI: img.API, fetched: map[digest.Digest]int{}}
+	img.API = counter
+
+	// two symlink hops, each walking the layers again
+	buf := &bytes.Buffer{}
+	if _, err := img.ReadFile("/lib/libc.so.6", buf); err != nil {
+		t.Fatalf("Failed to read /lib/libc.so.6: %s", err)
+	}
+	if buf.String() != "libc" {
+		t.Errorf("Read %q, expected %q", buf.String(), "libc")
+	}
+	if _, err := img.Merge(false); err != nil {
+		t.Fatalf("Failed to merge layers: %s", err)
+	}
+
+	for _, layer := range img.Manifest.Layers {
+		if counter.fetched[layer.Digest] != 1 {
+			t.Errorf("Layer %s fetched %d times, expected once", layer.Digest, counter.fetched[layer.Digest])
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package layer
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+type HistoryEntry struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"emptyLayer,omitempty"`
+	// the layer the entry created, none for an empty layer
+	Layer     digest.Digest `json:"layer,omitempty"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+}
+
+// History pairs the config's history with the layers it created, in order,
+// entries with empty_layer creating none.  Layers without history get
+// entries of their own.
+func (img *Image) History() []HistoryEntry {
+	entries := []HistoryEntry{}
+	layers := img.Manifest.Layers
+	for _, h := range img.Config.History {
+		entry := HistoryEntry{
+			Created:    h.Created,
+			CreatedBy:  h.CreatedBy,
+			Author:     h.Author,
+			Comment:    h.Comment,
+			EmptyLayer: h.EmptyLayer,
+		}
+		if !h.EmptyLayer && len(layers) > 0 {
+			entry.Layer = layers[0].Digest
+			entry.MediaType = layers[0].MediaType
+			entry.Size = layers[0].Size
+			layers = layers[1:]
+		} else if !h.EmptyLayer {
+			log.Debugf("Image.History() more history entries with layers than the %d layers of %s", len(img.Manifest.Layers), img.Digest)
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, layer := range layers {
+		entries = append(entries, HistoryEntry{Layer: layer.Digest, MediaType: layer.MediaType, Size: layer.Size})
+	}
+	return entries
+}
@@ -0,0 +1,77 @@
+package layer
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestHistory(t *testing.T) {
+	layers := []ispec.Descriptor{
+		{MediaType: ispec.MediaTypeImageLayerGzip, Digest: digest.FromString("base"), Size: 100},
+		{MediaType: ispec.MediaTypeImageLayerGzip, Digest: digest.FromString("app"), Size: 10},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		history []ispec.History
+		layers  []ispec.Descriptor
+		// the layer of each entry, "" for none
+		expected []digest.Digest
+	}{
+		{
+			name:     "paired",
+			history:  []ispec.History{{CreatedBy: "ADD base"}, {CreatedBy: "COPY app"}},
+			layers:   layers,
+			expected: []digest.Digest{layers[0].Digest, layers[1].Digest},
+		},
+		{
+			name:     "empty-layers",
+			history:  []ispec.History{{CreatedBy: "ADD base"}, {CreatedBy: "ENV A=1", EmptyLayer: true}, {CreatedBy: "COPY app"}, {CreatedBy: "CMD app", EmptyLayer: true}},
+			layers:   layers,
+			expected: []digest.Digest{layers[0].Digest, "", layers[1].Digest, ""},
+		},
+		// layers without history get entries of their own
+		{
+			name:     "no-history",
+			layers:   layers,
+			expected: []digest.Digest{layers[0].Digest, layers[1].Digest},
+		},
+		{
+			name:     "short-history",
+			history:  []ispec.History{{CreatedBy: "ADD base"}},
+			layers:   layers,
+			expected: []digest.Digest{layers[0].Digest, layers[1].Digest},
+		},
+		// more history than layers leaves the rest without one
+		{
+			name:     "long-history",
+			history:  []ispec.History{{CreatedBy: "ADD base"}, {CreatedBy: "COPY app"}, {CreatedBy: "RUN make"}},
+			layers:   layers,
+			expected: []digest.Digest{layers[0].Digest, layers[1].Digest, ""},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img := &Image{
+				Manifest: ispec.Manifest{Layers: tc.layers},
+				Config:   ispec.Image{History: tc.history},
+			}
+			entries := img.History()
+			if len(entries) != len(tc.expected) {
+				t.Fatalf("Got %d entries, expected %d: %+v", len(entries), len(tc.expected), entries)
+			}
+			for i, entry := range entries {
+				if entry.Layer != tc.expected[i] {
+					t.Errorf("Entry %d has layer %q, expected %q", i, entry.Layer, tc.expected[i])
+				}
+				if i < len(tc.history) && entry.CreatedBy != tc.history[i].CreatedBy {
+					t.Errorf("Entry %d created by %q, expected %q", i, entry.CreatedBy, tc.history[i].CreatedBy)
+				}
+				if entry.Layer != "" && (entry.Size == 0 || entry.MediaType == "") {
+					t.Errorf("Entry %d is missing the size or media type of its layer", i)
+				}
+			}
+		})
+	}
+}
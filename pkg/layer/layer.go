@@ -0,0 +1,183 @@
+// Package layer reads the files of an image's layers straight from the blobs
+// of an OCIAPI, one layer at a time or merged with whiteouts applied.
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	// hides the lower layers' contents of the directory it is in
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// Image is an image manifest and config, with its layers read on demand
+type Image struct {
+	API      api.OCIAPI
+	Digest   digest.Digest
+	Manifest ispec.Manifest
+	Config   ispec.Image
+
+	// layer blobs already fetched, walks and lookups read each layer many
+	// times
+	blobs map[digest.Digest][]byte
+	lock  sync.Mutex
+}
+
+// WalkFunc is called with each entry of a layer, name being its clean
+// absolute path.  r reads the entry's content.
+type WalkFunc func(name string, hdr *tar.Header, r io.Reader) error
+
+// Open resolves ref to an image manifest, the image for platform of an
+// index, and reads its config.
+func Open(ociApi api.OCIAPI, ref string, platform ispec.Platform) (*Image, error) {
+	desc, content, err := api.ResolveManifest(ociApi, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{API: ociApi, Digest: desc.Digest}
+	if err := json.Unmarshal(content, &img.Manifest); err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest '%s': %s", desc.Digest, err)
+	}
+
+	config, err := ociApi.GetBlob(&img.Manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get config '%s': %s", img.Manifest.Config.Digest, err)
+	}
+	if err := json.Unmarshal(config, &img.Config); err != nil {
+		return nil, fmt.Errorf("Failed to parse config '%s': %s", img.Manifest.Config.Digest, err)
+	}
+	return img, nil
+}
+
+// Layer returns the uncompressed tar stream of layer n, whatever its
+// compression.
+func (img *Image) Layer(n int) (io.ReadCloser, error) {
+	if n < 0 || n >= len(img.Manifest.Layers) {
+		return nil, fmt.Errorf("Image has no layer %d, it has %d layers", n, len(img.Manifest.Layers))
+	}
+	desc := img.Manifest.Layers[n]
+
+	log.WithFields(log.Fields{
+		"layer":  n,
+		"digest": desc.Digest,
+	}).Debug("Image.Layer() reading layer")
+
+	blob, err := img.layerBlob(desc)
+	if err != nil {
+		return nil, err
+	}
+
+	_, decompressor, reader, err := compression.DetectCompressionFormat(bytes.NewReader(blob))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to detect compression of layer '%s': %s", desc.Digest, err)
+	}
+	if decompressor == nil {
+		return io.NopCloser(reader), nil
+	}
+	decompressed, err := decompressor(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress layer '%s': %s", desc.Digest, err)
+	}
+	return decompressed, nil
+}
+
+// layerBlob returns the blob of a layer, fetching it only the first time
+func (img *Image) layerBlob(desc ispec.Descriptor) ([]byte, error) {
+	img.lock.Lock()
+	defer img.lock.Unlock()
+
+	if blob, ok := img.blobs[desc.Digest]; ok {
+		return blob, nil
+	}
+
+	blob, err := img.API.GetBlob(&desc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get layer '%s': %s", desc.Digest, err)
+	}
+	if dgst := digest.FromBytes(blob); dgst != desc.Digest {
+		return nil, fmt.Errorf("Layer content does not match digest '%s'", desc.Digest)
+	}
+
+	if img.blobs == nil {
+		img.blobs = map[digest.Digest][]byte{}
+	}
+	img.blobs[desc.Digest] = blob
+	return blob, nil
+}
+
+// WalkLayer calls fn with each entry of layer n, in archive order
+func (img *Image) WalkLayer(n int, fn WalkFunc) error {
+	reader, err := img.Layer(n)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read layer '%s': %s", img.Manifest.Layers[n].Digest, err)
+		}
+
+		name := CleanPath(hdr.Name)
+		if name == "/" {
+			continue
+		}
+		if err := fn(name, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// CleanPath returns name as an absolute path with no ".." leaving the root
+func CleanPath(name string) string {
+	return path.Join("/", name)
+}
+
+// whiteout returns the path a whiteout entry hides, and if it is opaque the
+// directory whose lower contents it hides.
+func whiteout(name string) (string, bool, bool) {
+	dir, file := path.Split(name)
+	if file == opaqueWhiteout {
+		return path.Clean(dir), true, true
+	}
+	if strings.HasPrefix(file, whiteoutPrefix) {
+		return path.Join(dir, strings.TrimPrefix(file, whiteoutPrefix)), false, true
+	}
+	return "", false, false
+}
+
+// IsWhiteout reports if name is a whiteout entry, hiding lower layers' files
+func IsWhiteout(name string) bool {
+	_, _, ok := whiteout(name)
+	return ok
+}
+
+// isUnder reports if name is dir or inside it
+func isUnder(name, dir string) bool {
+	if dir == "/" || name == dir {
+		return true
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,65 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/raharper/ocidist/pkg/api/apitest"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+	mode     int64
+}
+
+// testLayer returns a tar layer of entries
+func testLayer(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     e.mode,
+			Size:     int64(len(e.content)),
+			ModTime:  time.Unix(1700000000, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write header of %s: %s", e.name, err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Failed to write %s: %s", e.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close layer: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// openTestImage puts an image of layers, bottom first, in a mem:// store
+func openTestImage(t *testing.T, layers ...[]testEntry) *Image {
+	t.Helper()
+	ociApi := apitest.NewMemRepo(t, "test/img:v1")
+
+	blobs := [][]byte{}
+	for _, entries := range layers {
+		blobs = append(blobs, testLayer(t, entries))
+	}
+	apitest.PushImage(t, ociApi, blobs...)
+
+	img, err := Open(ociApi, ociApi.RepoTag(), ispec.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("Failed to open image: %s", err)
+	}
+	return img
+}
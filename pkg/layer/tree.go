@@ -0,0 +1,156 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+type Entry struct {
+	Path   string
+	Header *tar.Header
+	// the index of the layer the entry comes from
+	Layer int
+	// of a regular file's or a hardlink's content, if the tree was hashed
+	Digest digest.Digest
+}
+
+// Tree is the merged filesystem of an image's layers
+type Tree struct {
+	entries map[string]*Entry
+}
+
+// Merge applies every layer in order, each layer's whiteouts hiding the
+// entries of the layers below.  hash records the digest of file contents.
+func (img *Image) Merge(hash bool) (*Tree, error) {
+	tree := &Tree{entries: map[string]*Entry{}}
+	for n := range img.Manifest.Layers {
+		if err := tree.apply(img, n, hash); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// apply merges layer n over the tree.  Whiteouts only hide lower layers, so
+// they are applied before any entry of the layer is added.
+func (t *Tree) apply(img *Image, n int, hash bool) error {
+	hidden := []string{}
+	opaque := []string{}
+	entries := []*Entry{}
+
+	err := img.WalkLayer(n, func(name string, hdr *tar.Header, r io.Reader) error {
+		if target, isOpaque, ok := whiteout(name); ok {
+			if isOpaque {
+				opaque = append(opaque, target)
+			} else {
+				hidden = append(hidden, target)
+			}
+			return nil
+		}
+
+		entry := &Entry{Path: name, Header: hdr, Layer: n}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = CleanPath(hdr.Linkname)
+		}
+		if hash && hdr.Typeflag == tar.TypeReg {
+			dgst, err := digest.FromReader(r)
+			if err != nil {
+				return fmt.Errorf("Failed to read '%s': %s", name, err)
+			}
+			entry.Digest = dgst
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range opaque {
+		t.removeUnder(dir, false)
+	}
+	for _, name := range hidden {
+		t.removeUnder(name, true)
+	}
+
+	for _, entry := range entries {
+		name, err := t.resolveParents(entry.Path)
+		if err != nil {
+			return fmt.Errorf("Layer '%s' has '%s': %s", img.Manifest.Layers[n].Digest, entry.Path, err)
+		}
+		entry.Path = name
+		if existing, ok := t.entries[entry.Path]; ok && existing.Header.Typeflag == tar.TypeDir && entry.Header.Typeflag != tar.TypeDir {
+			t.removeUnder(entry.Path, false)
+		}
+		if entry.Header.Typeflag == tar.TypeLink {
+			if target, ok := t.entries[entry.Header.Linkname]; ok {
+				entry.Digest = target.Digest
+			}
+		}
+		t.entries[entry.Path] = entry
+	}
+	return nil
+}
+
+// removeUnder removes everything inside dir, and dir itself if self
+func (t *Tree) removeUnder(dir string, self bool) {
+	for name := range t.entries {
+		if isUnder(name, dir) && (self || name != dir) {
+			delete(t.entries, name)
+		}
+	}
+}
+
+// resolveParents returns name with the symlinks among its parents resolved,
+// as extracting a layer writes through them, e.g. /lib/x under a /lib ->
+// usr/lib symlink of a lower layer is /usr/lib/x.  Other files in the way
+// are replaced by the directory the entry implies.
+func (t *Tree) resolveParents(name string) (string, error) {
+	hops := 0
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		dir := "/" + path.Join(parts[:i+1]...)
+		entry, ok := t.entries[dir]
+		if !ok || entry.Header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if entry.Header.Typeflag != tar.TypeSymlink {
+			delete(t.entries, dir)
+			continue
+		}
+
+		if hops++; hops > maxSymlinks {
+			return "", fmt.Errorf("Too many levels of symlinks resolving '%s'", name)
+		}
+		// resolve the symlink's target from the root again
+		name = path.Join(symlinkTarget(entry), path.Join(parts[i+1:]...))
+		parts = strings.Split(strings.TrimPrefix(name, "/"), "/")
+		i = -1
+	}
+	return name, nil
+}
+
+// Get returns the entry at the clean absolute path name
+func (t *Tree) Get(name string) (*Entry, bool) {
+	entry, ok := t.entries[name]
+	return entry, ok
+}
+
+// Entries returns every entry sorted by path, so parents come before their
+// contents.
+func (t *Tree) Entries() []*Entry {
+	entries := make([]*Entry, 0, len(t.entries))
+	for _, entry := range t.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
@@ -0,0 +1,94 @@
+package layer
+
+import (
+	"archive/tar"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "etc/passwd", typeflag: tar.TypeReg, content: "root", mode: 0644},
+		{name: "etc/shadow", typeflag: tar.TypeReg, content: "x", mode: 0600},
+		{name: "usr/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "usr/lib/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "usr/lib/libc.so", typeflag: tar.TypeReg, content: "libc", mode: 0755},
+		{name: "lib", typeflag: tar.TypeSymlink, linkname: "usr/lib", mode: 0777},
+		{name: "var/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "var/cache/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "var/cache/old", typeflag: tar.TypeReg, content: "old", mode: 0644},
+		{name: "opt", typeflag: tar.TypeReg, content: "file", mode: 0644},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		upper   []testEntry
+		present []string
+		absent  []string
+	}{{
+		name: "whiteout",
+		upper: []testEntry{
+			{name: "etc/.wh.shadow", typeflag: tar.TypeReg},
+			{name: ".wh.var", typeflag: tar.TypeReg},
+		},
+		present: []string{"/etc/passwd"},
+		absent:  []string{"/etc/shadow", "/etc/.wh.shadow", "/var", "/var/cache/old"},
+	}, {
+		name: "opaque",
+		upper: []testEntry{
+			{name: "var/cache/", typeflag: tar.TypeDir, mode: 0755},
+			{name: "var/cache/.wh..wh..opq", typeflag: tar.TypeReg},
+			{name: "var/cache/new", typeflag: tar.TypeReg, content: "new", mode: 0644},
+		},
+		present: []string{"/var/cache", "/var/cache/new"},
+		absent:  []string{"/var/cache/old", "/var/cache/.wh..wh..opq"},
+	}, {
+		// written through the symlink, as extracting the layer does
+		name: "symlink-parent",
+		upper: []testEntry{
+			{name: "lib/libm.so", typeflag: tar.TypeReg, content: "libm", mode: 0755},
+		},
+		present: []string{"/lib", "/usr/lib/libc.so", "/usr/lib/libm.so"},
+		absent:  []string{"/lib/libm.so"},
+	}, {
+		name: "file-parent",
+		upper: []testEntry{
+			{name: "opt/app", typeflag: tar.TypeReg, content: "app", mode: 0755},
+		},
+		present: []string{"/opt/app"},
+		absent:  []string{"/opt"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			img := openTestImage(t, base, tc.upper)
+			tree, err := img.Merge(false)
+			if err != nil {
+				t.Fatalf("Failed to merge layers: %s", err)
+			}
+			for _, name := range tc.present {
+				if _, ok := tree.Get(name); !ok {
+					t.Errorf("%s is missing from the merged tree", name)
+				}
+			}
+			for _, name := range tc.absent {
+				if _, ok := tree.Get(name); ok {
+					t.Errorf("%s is in the merged tree", name)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeSymlinkLoop(t *testing.T) {
+	img := openTestImage(t,
+		[]testEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "b", mode: 0777},
+			{name: "b", typeflag: tar.TypeSymlink, linkname: "a", mode: 0777},
+		},
+		[]testEntry{
+			{name: "a/file", typeflag: tar.TypeReg, content: "file", mode: 0644},
+		},
+	)
+	if _, err := img.Merge(false); err == nil {
+		t.Errorf("Merge through a symlink loop succeeded")
+	}
+}
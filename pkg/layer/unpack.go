@@ -0,0 +1,138 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	umocilayer "github.com/opencontainers/umoci/oci/layer"
+	log "github.com/sirupsen/logrus"
+)
+
+type UnpackOpts struct {
+	// do not fail when owners cannot be set, as when not running as root
+	Rootless bool
+	// map the image's owners to those of the host
+	UIDMappings []rspec.LinuxIDMapping
+	GIDMappings []rspec.LinuxIDMapping
+}
+
+// Unpack applies every layer in order to dir, an empty or new directory,
+// leaving the image's root filesystem in it.
+func (img *Image) Unpack(dir string, opts UnpackOpts) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("Refusing to unpack into '%s' which is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create '%s': %s", dir, err)
+	}
+
+	unpackOpts := &umocilayer.UnpackOptions{
+		MapOptions: umocilayer.MapOptions{
+			Rootless:    opts.Rootless,
+			UIDMappings: opts.UIDMappings,
+			GIDMappings: opts.GIDMappings,
+		},
+	}
+
+	for n, desc := range img.Manifest.Layers {
+		log.WithFields(log.Fields{
+			"dir":    dir,
+			"layer":  n,
+			"digest": desc.Digest,
+		}).Debug("Image.Unpack() unpacking layer")
+
+		reader, err := img.Layer(n)
+		if err != nil {
+			return err
+		}
+		err = umocilayer.UnpackLayer(dir, reader, unpackOpts)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to unpack layer '%s': %s", desc.Digest, err)
+		}
+	}
+	return nil
+}
+
+// Export writes the merged filesystem as one tar stream with no whiteouts.
+// Directories come first, then the files of each layer from the bottom up,
+// so a hardlink always follows its target.
+func (img *Image) Export(w io.Writer) error {
+	tree, err := img.Merge(false)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	entries := tree.Entries()
+	for _, entry := range entries {
+		if entry.Header.Typeflag == tar.TypeDir {
+			if err := writeHeader(tw, entry.Path, entry.Header); err != nil {
+				return err
+			}
+		}
+	}
+
+	for n := range img.Manifest.Layers {
+		err := img.WalkLayer(n, func(name string, hdr *tar.Header, r io.Reader) error {
+			entry, ok := tree.Get(name)
+			if !ok || entry.Layer != n || hdr.Typeflag == tar.TypeDir || IsWhiteout(name) {
+				return nil
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				return img.exportLink(tw, tree, entry)
+			}
+			if err := writeHeader(tw, name, hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, r); err != nil {
+				return fmt.Errorf("Failed to export '%s': %s", name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// exportLink writes a hardlink, or a copy of the content it shares when a
+// layer above the link replaced its target.
+func (img *Image) exportLink(tw *tar.Writer, tree *Tree, link *Entry) error {
+	if target, ok := tree.Get(link.Header.Linkname); ok && target.Layer <= link.Layer {
+		hdr := *link.Header
+		hdr.Linkname = strings.TrimPrefix(link.Header.Linkname, "/")
+		return writeHeader(tw, link.Path, &hdr)
+	}
+
+	target, err := img.resolveHardlink(link)
+	if err != nil {
+		return err
+	}
+	hdr := *link.Header
+	hdr.Typeflag = tar.TypeReg
+	hdr.Linkname = ""
+	hdr.Size = target.Header.Size
+	if err := writeHeader(tw, link.Path, &hdr); err != nil {
+		return err
+	}
+	return img.copyContent(target, tw)
+}
+
+// writeHeader writes hdr named by the relative form of the path name
+func writeHeader(tw *tar.Writer, name string, hdr *tar.Header) error {
+	out := *hdr
+	out.Name = strings.TrimPrefix(name, "/")
+	if out.Typeflag == tar.TypeDir {
+		out.Name += "/"
+	}
+	if err := tw.WriteHeader(&out); err != nil {
+		return fmt.Errorf("Failed to export '%s': %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Package progress reports the steps of a copy as events, for programs to
+// follow instead of the human readable report.
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	BlobStart = "blob-start"
+	// Offset bytes of the blob have been transferred
+	BlobProgress = "blob-progress"
+	BlobDone     = "blob-done"
+	// the blob was already at the destination
+	BlobSkipped = "blob-skipped"
+	// the blob was linked from another repository of the destination
+	BlobMounted    = "blob-mounted"
+	ManifestPushed = "manifest-pushed"
+	ReferrerPushed = "referrer-pushed"
+	Done           = "done"
+	Error          = "error"
+)
+
+type Event struct {
+	Time         time.Time     `json:"time"`
+	Type         string        `json:"event"`
+	Digest       digest.Digest `json:"digest,omitempty"`
+	MediaType    string        `json:"mediaType,omitempty"`
+	ArtifactType string        `json:"artifactType,omitempty"`
+	Size         int64         `json:"size,omitempty"`
+	Offset       int64         `json:"offset,omitempty"`
+	// the tag or digest a manifest was pushed to
+	Ref string `json:"ref,omitempty"`
+	// the URLs of a done or error event
+	Source string `json:"source,omitempty"`
+	Dest   string `json:"dest,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Reporter receives the events of a copy, from concurrent transfers at once
+type Reporter interface {
+	Report(Event)
+}
+
+// ReporterFunc adapts a function to a Reporter
+type ReporterFunc func(Event)
+
+func (f ReporterFunc) Report(ev Event) {
+	f(ev)
+}
+
+type jsonReporter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONReporter writes each event to w as one line of JSON
+func NewJSONReporter(w io.Writer) Reporter {
+	return &jsonReporter{encoder: json.NewEncoder(w)}
+}
+
+func (jr *jsonReporter) Report(ev Event) {
+	jr.lock.Lock()
+	defer jr.lock.Unlock()
+	jr.encoder.Encode(ev)
+}
+
+// Send reports ev, stamped with the current time, if there is a reporter
+func Send(r Reporter, ev Event) {
+	if r == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	r.Report(ev)
+}
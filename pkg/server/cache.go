@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// distribution-spec tag, also keeps tags from escaping the cache directory
+var tagRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// BlobCache is a content-addressed store of blobs and manifests, along with
+// a record of which digest each cached tag pointed to and when.
+//
+//	<dir>/blobs/<algo>/<hash>
+//	<dir>/manifests/<algo>/<hash>, the media type of a cached manifest
+//	<dir>/tags/<repo name>/<tag>.json
+type BlobCache struct {
+	dir string
+}
+
+// TagRecord is the cached resolution of a tag to a manifest.
+type TagRecord struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Fetched   time.Time     `json:"fetched"`
+}
+
+func NewBlobCache(dir string) (*BlobCache, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get absolute path of %q: %s", dir, err)
+	}
+
+	for _, sub := range []string{"blobs", "manifests", "tags", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create cache directory %q: %s", filepath.Join(dir, sub), err)
+		}
+	}
+	return &BlobCache{dir: dir}, nil
+}
+
+func (bc *BlobCache) BlobPath(dgst digest.Digest) string {
+	return filepath.Join(bc.dir, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (bc *BlobCache) OpenBlob(dgst digest.Digest) (*os.File, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(bc.BlobPath(dgst))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return fh, nil
+}
+
+// HasBlob reports if the blob at dgst is cached
+func (bc *BlobCache) HasBlob(dgst digest.Digest) bool {
+	fh, err := bc.OpenBlob(dgst)
+	if err != nil {
+		return false
+	}
+	fh.Close()
+	return true
+}
+
+func (bc *BlobCache) ReadBlob(dgst digest.Digest) ([]byte, error) {
+	fh, err := bc.OpenBlob(dgst)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return io.ReadAll(fh)
+}
+
+// PutBlob writes reader to the cache, only committing it if the content
+// matches the expected digest.
+func (bc *BlobCache) PutBlob(reader io.Reader, expected digest.Digest) error {
+	if err := expected.Validate(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(bc.dir, "tmp"), "blob-")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary blob: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := expected.Verifier()
+	if _, err := io.Copy(io.MultiWriter(tmp, verifier), reader); err != nil {
+		return fmt.Errorf("Failed to write temporary blob: %s", err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("Blob content does not match digest '%s'", expected)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to close temporary blob: %s", err)
+	}
+
+	blobPath := bc.BlobPath(expected)
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return fmt.Errorf("Failed to create blob directory: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), blobPath); err != nil {
+		return fmt.Errorf("Failed to commit blob '%s': %s", expected, err)
+	}
+	return nil
+}
+
+func (bc *BlobCache) manifestPath(dgst digest.Digest) string {
+	return filepath.Join(bc.dir, "manifests", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// PutManifest caches a manifest's content along with its media type, which
+// tells it apart from other blobs
+func (bc *BlobCache) PutManifest(content []byte, dgst digest.Digest, mediaType string) error {
+	if err := bc.PutBlob(bytes.NewReader(content), dgst); err != nil {
+		return err
+	}
+	if err := bc.writeFile(bc.manifestPath(dgst), []byte(mediaType)); err != nil {
+		return fmt.Errorf("Failed to record cached manifest '%s': %s", dgst, err)
+	}
+	return nil
+}
+
+// ReadManifest returns the media type and content of a cached manifest,
+// ErrNotFound if dgst was not cached as a manifest.
+func (bc *BlobCache) ReadManifest(dgst digest.Digest) (string, []byte, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", nil, err
+	}
+	mediaType, err := os.ReadFile(bc.manifestPath(dgst))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, ErrNotFound
+		}
+		return "", nil, err
+	}
+	content, err := bc.ReadBlob(dgst)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(mediaType), content, nil
+}
+
+func (bc *BlobCache) tagPath(name, tag string) string {
+	return filepath.Join(bc.dir, "tags", filepath.FromSlash(name), tag+".json")
+}
+
+func (bc *BlobCache) GetTag(name, tag string) (*TagRecord, error) {
+	if !tagRegex.MatchString(tag) {
+		return nil, fmt.Errorf("invalid tag %q", tag)
+	}
+
+	content, err := os.ReadFile(bc.tagPath(name, tag))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var record TagRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal cached tag %s:%s: %s", name, tag, err)
+	}
+	return &record, nil
+}
+
+func (bc *BlobCache) PutTag(name, tag string, record TagRecord) error {
+	if !tagRegex.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := bc.writeFile(bc.tagPath(name, tag), content); err != nil {
+		return fmt.Errorf("Failed to write cached tag %s:%s: %s", name, tag, err)
+	}
+	return nil
+}
+
+// writeFile replaces the file at path with content through a temporary file
+func (bc *BlobCache) writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(bc.dir, "tmp"), "file-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(content); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleManifests(w http.ResponseWriter, r *http.Request, rt *route) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.getManifest(w, r, rt)
+	case http.MethodPut:
+		s.putManifest(w, r, rt)
+	case http.MethodDelete:
+		s.deleteManifest(w, r, rt)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+	}
+}
+
+func (s *Server) getManifest(w http.ResponseWriter, r *http.Request, rt *route) {
+	layout, err := s.layouts.Open(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, err.Error())
+		return
+	}
+
+	desc, err := layout.Resolve(rt.Ref)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, fmt.Sprintf("manifest %q unknown to repository %q: %s", rt.Ref, rt.Name, err))
+		return
+	}
+
+	content, err := layout.ReadBlob(desc.Digest)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, fmt.Sprintf("manifest %q unknown to repository %q: %s", rt.Ref, rt.Name, err))
+		return
+	}
+
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		mediaType, _ = detectMediaType(content)
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+	w.Header().Set("Docker-Content-Digest", desc.Digest.String())
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (s *Server) putManifest(w http.ResponseWriter, r *http.Request, rt *route) {
+	content, err := io.ReadAll(io.LimitReader(r.Body, MaxManifestSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, fmt.Sprintf("Failed to read manifest: %s", err))
+		return
+	}
+	if len(content) > MaxManifestSize {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodeSizeInvalid, "manifest exceeds maximum size")
+		return
+	}
+
+	mediaType := r.Header.Get("Content-Type")
+	if mediaType == "" {
+		mediaType, err = detectMediaType(content)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, err.Error())
+			return
+		}
+	}
+
+	dgst := digest.FromBytes(content)
+	if rt.IsDigest && digest.Digest(rt.Ref) != dgst {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("manifest digest '%s' does not match reference '%s'", dgst, rt.Ref))
+		return
+	}
+
+	layout, err := s.layouts.OpenOrCreate(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	var manifest ispec.Manifest
+	var index ispec.Index
+	switch mediaType {
+	case ispec.MediaTypeImageIndex:
+		if err := json.Unmarshal(content, &index); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, fmt.Sprintf("Failed to unmarshal index: %s", err))
+			return
+		}
+		for _, child := range index.Manifests {
+			if _, err := layout.StatBlob(child.Digest); err != nil {
+				writeError(w, http.StatusBadRequest, ErrCodeManifestBlobUnknown, fmt.Sprintf("index references unknown manifest '%s'", child.Digest))
+				return
+			}
+		}
+	default:
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, fmt.Sprintf("Failed to unmarshal manifest: %s", err))
+			return
+		}
+		blobs := append([]ispec.Descriptor{manifest.Config}, manifest.Layers...)
+		for _, blob := range blobs {
+			if blob.Digest == "" {
+				continue
+			}
+			if _, err := layout.StatBlob(blob.Digest); err != nil {
+				writeError(w, http.StatusBadRequest, ErrCodeManifestBlobUnknown, fmt.Sprintf("manifest references unknown blob '%s'", blob.Digest))
+				return
+			}
+		}
+	}
+
+	if _, err := layout.PutManifest(rt.Ref, mediaType, content); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"name":      rt.Name,
+		"ref":       rt.Ref,
+		"digest":    dgst,
+		"mediaType": mediaType,
+	}).Debug("Server.putManifest() stored manifest")
+
+	if manifest.Subject != nil {
+		w.Header().Set("OCI-Subject", manifest.Subject.Digest.String())
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", rt.Name, dgst))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) deleteManifest(w http.ResponseWriter, r *http.Request, rt *route) {
+	layout, err := s.layouts.Open(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, err.Error())
+		return
+	}
+
+	if err := layout.DeleteManifest(rt.Ref); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, fmt.Sprintf("manifest %q unknown to repository %q", rt.Ref, rt.Name))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleBlobs(w http.ResponseWriter, r *http.Request, rt *route) {
+	if !rt.IsDigest {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("invalid digest %q", rt.Ref))
+		return
+	}
+	dgst := digest.Digest(rt.Ref)
+
+	layout, err := s.layouts.Open(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		blob, err := layout.OpenBlob(dgst)
+		if err != nil {
+			writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, fmt.Sprintf("blob '%s' unknown to repository %q", dgst, rt.Name))
+			return
+		}
+		defer blob.Close()
+
+		info, err := blob.Stat()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		// handles HEAD, Content-Length and Range requests
+		http.ServeContent(w, r, "", info.ModTime(), blob)
+	case http.MethodDelete:
+		if err := layout.DeleteBlob(dgst); err != nil {
+			writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, fmt.Sprintf("blob '%s' unknown to repository %q", dgst, rt.Name))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+	}
+}
+
+func (s *Server) handleReferrers(w http.ResponseWriter, r *http.Request, rt *route) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+	if !rt.IsDigest {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("invalid digest %q", rt.Ref))
+		return
+	}
+
+	// unknown repositories and subjects return an empty list
+	refs := &ispec.Index{MediaType: ispec.MediaTypeImageIndex, Manifests: []ispec.Descriptor{}}
+	refs.SchemaVersion = 2
+
+	artifactType := r.URL.Query().Get("artifactType")
+	if layout, err := s.layouts.Open(rt.Name); err == nil {
+		refs, err = layout.Referrers(digest.Digest(rt.Ref), artifactType)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+			return
+		}
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	writeJSON(w, http.StatusOK, ispec.MediaTypeImageIndex, refs)
+}
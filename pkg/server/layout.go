@@ -0,0 +1,470 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci"
+	"github.com/opencontainers/umoci/oci/cas"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	uploadsDir = ".uploads"
+
+	// manifests larger than this are rejected
+	MaxManifestSize = 4 * 1024 * 1024
+)
+
+var ErrNotFound = fmt.Errorf("not found")
+
+// LayoutStore is a directory of OCI layouts, one per repository name.
+type LayoutStore struct {
+	root string
+	// serializes updates to layout index.json files
+	lock sync.Mutex
+}
+
+func NewLayoutStore(root string) (*LayoutStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get absolute path of %q: %s", root, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, uploadsDir), 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create uploads directory in %q: %s", root, err)
+	}
+
+	return &LayoutStore{root: root}, nil
+}
+
+func (ls *LayoutStore) UploadsDir() string {
+	return filepath.Join(ls.root, uploadsDir)
+}
+
+func (ls *LayoutStore) LayoutDir(name string) string {
+	return filepath.Join(ls.root, filepath.FromSlash(name))
+}
+
+// Repositories returns the names of all layouts under the store root.
+func (ls *LayoutStore) Repositories() ([]string, error) {
+	repos := []string{}
+	err := filepath.Walk(ls.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != ls.root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, ispec.ImageLayoutFile)); err != nil {
+			return nil
+		}
+		name, err := filepath.Rel(ls.root, path)
+		if err != nil {
+			return err
+		}
+		repos = append(repos, filepath.ToSlash(name))
+		// layouts do not nest
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to walk server root %q: %s", ls.root, err)
+	}
+	return repos, nil
+}
+
+func (ls *LayoutStore) Open(name string) (*Layout, error) {
+	layoutDir := ls.LayoutDir(name)
+	if _, err := os.Stat(filepath.Join(layoutDir, ispec.ImageLayoutFile)); err != nil {
+		return nil, fmt.Errorf("Repository %q is not known to this server", name)
+	}
+	return &Layout{Name: name, Dir: layoutDir, store: ls}, nil
+}
+
+// OpenOrCreate opens the layout for name, creating an empty layout if
+// the repository does not exist yet.
+func (ls *LayoutStore) OpenOrCreate(name string) (*Layout, error) {
+	if layout, err := ls.Open(name); err == nil {
+		return layout, nil
+	}
+
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	layoutDir := ls.LayoutDir(name)
+	if _, err := os.Stat(filepath.Join(layoutDir, ispec.ImageLayoutFile)); err == nil {
+		return &Layout{Name: name, Dir: layoutDir, store: ls}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(layoutDir), 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create directory for repository %q: %s", name, err)
+	}
+
+	engine, err := umoci.CreateLayout(layoutDir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create OCI Layout at directory %q: %s", layoutDir, err)
+	}
+	engine.Close()
+
+	log.WithFields(log.Fields{
+		"name":      name,
+		"layoutDir": layoutDir,
+	}).Debug("LayoutStore.OpenOrCreate() created new layout")
+
+	return &Layout{Name: name, Dir: layoutDir, store: ls}, nil
+}
+
+// Layout is a single repository in a LayoutStore.
+type Layout struct {
+	Name  string
+	Dir   string
+	store *LayoutStore
+}
+
+func (l *Layout) BlobPath(dgst digest.Digest) string {
+	return filepath.Join(l.Dir, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (l *Layout) StatBlob(dgst digest.Digest) (os.FileInfo, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(l.BlobPath(dgst))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+func (l *Layout) OpenBlob(dgst digest.Digest) (*os.File, error) {
+	if _, err := l.StatBlob(dgst); err != nil {
+		return nil, err
+	}
+	return os.Open(l.BlobPath(dgst))
+}
+
+// PutBlob stores the content of reader in the layout and verifies it matches
+// the expected digest.
+func (l *Layout) PutBlob(reader io.Reader, expected digest.Digest) (int64, error) {
+	if expected.Algorithm() != digest.SHA256 {
+		return 0, fmt.Errorf("Unsupported digest algorithm '%s'", expected.Algorithm())
+	}
+
+	engine, err := umoci.OpenLayout(l.Dir)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to open OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	defer engine.Close()
+
+	dgst, size, err := engine.PutBlob(context.Background(), reader)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to write blob to OCI Layout at directory %q: %s", l.Dir, err)
+	}
+
+	if dgst != expected {
+		engine.DeleteBlob(context.Background(), dgst)
+		return 0, fmt.Errorf("Blob digest '%s' does not match expected digest '%s'", dgst, expected)
+	}
+
+	return size, nil
+}
+
+func (l *Layout) DeleteBlob(dgst digest.Digest) error {
+	if _, err := l.StatBlob(dgst); err != nil {
+		return err
+	}
+	return os.Remove(l.BlobPath(dgst))
+}
+
+// MountBlob links a blob from another layout in the same store.
+func (l *Layout) MountBlob(from *Layout, dgst digest.Digest) error {
+	if _, err := from.StatBlob(dgst); err != nil {
+		return err
+	}
+	if _, err := l.StatBlob(dgst); err == nil {
+		return nil
+	}
+
+	src := from.BlobPath(dgst)
+	dest := l.BlobPath(dgst)
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	reader, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = l.PutBlob(reader, dgst)
+	return err
+}
+
+func (l *Layout) Tags() ([]string, error) {
+	index, err := l.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, desc := range index.Manifests {
+		tag, ok := desc.Annotations[ispec.AnnotationRefName]
+		if !ok || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// Resolve returns the descriptor of the manifest or index referenced by
+// ref, which is either a tag or a digest.  A digest must be listed in the
+// layout index or referenced by an index it lists, other blobs are not
+// manifests.
+func (l *Layout) Resolve(ref string) (ispec.Descriptor, error) {
+	if dgst, err := digest.Parse(ref); err == nil {
+		index, err := l.getIndex()
+		if err != nil {
+			return ispec.Descriptor{}, err
+		}
+		desc, ok := l.findManifest(index.Manifests, dgst, map[digest.Digest]bool{})
+		if !ok {
+			return ispec.Descriptor{}, ErrNotFound
+		}
+		return ispec.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		}, nil
+	}
+
+	index, err := l.getIndex()
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+
+	// last entry wins, matching how UpdateReference appends
+	for idx := len(index.Manifests) - 1; idx >= 0; idx-- {
+		desc := index.Manifests[idx]
+		if desc.Annotations[ispec.AnnotationRefName] == ref {
+			return desc, nil
+		}
+	}
+	return ispec.Descriptor{}, ErrNotFound
+}
+
+// findManifest returns the descriptor of dgst among descs or the manifests
+// of the indexes in descs
+func (l *Layout) findManifest(descs []ispec.Descriptor, dgst digest.Digest, seen map[digest.Digest]bool) (ispec.Descriptor, bool) {
+	for _, desc := range descs {
+		if desc.Digest == dgst {
+			return desc, true
+		}
+	}
+
+	for _, desc := range descs {
+		if seen[desc.Digest] || (desc.MediaType != ispec.MediaTypeImageIndex && desc.MediaType != api.MediaTypeDockerManifestList) {
+			continue
+		}
+		seen[desc.Digest] = true
+
+		content, err := l.ReadBlob(desc.Digest)
+		if err != nil {
+			continue
+		}
+		var index ispec.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			continue
+		}
+		if found, ok := l.findManifest(index.Manifests, dgst, seen); ok {
+			return found, true
+		}
+	}
+	return ispec.Descriptor{}, false
+}
+
+func (l *Layout) ReadBlob(dgst digest.Digest) ([]byte, error) {
+	reader, err := l.OpenBlob(dgst)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// PutManifest stores a manifest or index and records it in the layout
+// index, under ref if ref is a tag or untagged if ref is a digest.
+func (l *Layout) PutManifest(ref, mediaType string, content []byte) (digest.Digest, error) {
+	dgst := digest.FromBytes(content)
+	if refDigest, err := digest.Parse(ref); err == nil && refDigest != dgst {
+		return "", fmt.Errorf("Manifest digest '%s' does not match reference '%s'", dgst, ref)
+	}
+
+	if _, err := l.PutBlob(bytes.NewReader(content), dgst); err != nil {
+		return "", err
+	}
+
+	desc := ispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(content)),
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err == nil && manifest.ArtifactType != "" {
+		desc.ArtifactType = manifest.ArtifactType
+	}
+
+	l.store.lock.Lock()
+	defer l.store.lock.Unlock()
+
+	engine, err := umoci.OpenLayout(l.Dir)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	defer engine.Close()
+
+	if _, err := digest.Parse(ref); err != nil {
+		if err := engine.UpdateReference(context.Background(), ref, desc); err != nil {
+			return "", fmt.Errorf("Failed to update reference '%s' in OCI Layout at directory %q: %s", ref, l.Dir, err)
+		}
+		return dgst, nil
+	}
+
+	// untagged manifests are kept in the index so referrers can be found
+	index, err := engine.GetIndex(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("Failed to get index from OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	for _, existing := range index.Manifests {
+		if existing.Digest == dgst {
+			return dgst, nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+	if err := engine.PutIndex(context.Background(), index); err != nil {
+		return "", fmt.Errorf("Failed to put index to OCI Layout at directory %q: %s", l.Dir, err)
+	}
+
+	return dgst, nil
+}
+
+// DeleteManifest removes a tag, or if ref is a digest, every index entry for
+// that manifest along with the manifest blob.
+func (l *Layout) DeleteManifest(ref string) error {
+	l.store.lock.Lock()
+	defer l.store.lock.Unlock()
+
+	engine, err := umoci.OpenLayout(l.Dir)
+	if err != nil {
+		return fmt.Errorf("Failed to open OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	defer engine.Close()
+
+	index, err := engine.GetIndex(context.Background())
+	if err != nil {
+		return fmt.Errorf("Failed to get index from OCI Layout at directory %q: %s", l.Dir, err)
+	}
+
+	dgst, dgstErr := digest.Parse(ref)
+	newManifests := []ispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		if dgstErr == nil && desc.Digest == dgst {
+			continue
+		}
+		if dgstErr != nil && desc.Annotations[ispec.AnnotationRefName] == ref {
+			continue
+		}
+		newManifests = append(newManifests, desc)
+	}
+
+	if dgstErr == nil {
+		if err := engine.DeleteBlob(context.Background(), dgst); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to delete manifest blob '%s': %s", dgst, err)
+		}
+	} else if len(newManifests) == len(index.Manifests) {
+		return ErrNotFound
+	}
+
+	index.Manifests = newManifests
+	if err := engine.PutIndex(context.Background(), index); err != nil {
+		return fmt.Errorf("Failed to put index to OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	return nil
+}
+
+func (l *Layout) Referrers(dgst digest.Digest, artifactType string) (*ispec.Index, error) {
+	layoutURL := &url.URL{Scheme: string(api.OCIDirRepoType), Path: l.Dir}
+	odr, err := api.NewOCIDirRepo(layoutURL, &api.OCIAPIConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := odr.GetReferrers(&ispec.Descriptor{Digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	refs.SchemaVersion = 2
+	seen := map[digest.Digest]bool{}
+	filtered := []ispec.Descriptor{}
+	for _, desc := range refs.Manifests {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			continue
+		}
+		filtered = append(filtered, desc)
+	}
+	refs.Manifests = filtered
+
+	return refs, nil
+}
+
+func (l *Layout) getIndex() (ispec.Index, error) {
+	engine, err := umoci.OpenLayout(l.Dir)
+	if err != nil {
+		return ispec.Index{}, fmt.Errorf("Failed to open OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	defer engine.Close()
+
+	index, err := engine.GetIndex(context.Background())
+	if err != nil {
+		return ispec.Index{}, fmt.Errorf("Failed to get index from OCI Layout at directory %q: %s", l.Dir, err)
+	}
+	return index, nil
+}
+
+// detectMediaType wraps api.DetectManifestMediaType errors as cas.ErrInvalid
+func detectMediaType(content []byte) (string, error) {
+	mediaType, err := api.DetectManifestMediaType(content)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", cas.ErrInvalid, err)
+	}
+	return mediaType, nil
+}
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/raharper/ocidist/pkg/api"
+
+	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Proxy is a read-only pull-through cache in front of an upstream registry.
+// Blobs and manifests fetched by digest are immutable and cached forever,
+// manifests fetched by tag are revalidated against upstream after the TTL.
+type Proxy struct {
+	upstream *url.URL
+	config   *api.OCIAPIConfig
+	cache    *BlobCache
+	ttl      time.Duration
+
+	// one fill per digest at a time
+	fillLock sync.Mutex
+	fills    map[digest.Digest]*blobFill
+}
+
+// blobFill serializes the fills of one digest, it is dropped once no request
+// waits on it.
+type blobFill struct {
+	lock    sync.Mutex
+	waiters int
+}
+
+func NewProxy(upstream, cacheDir string, ttl time.Duration, config *api.OCIAPIConfig) (*Proxy, error) {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse upstream url '%s': %s", upstream, err)
+	}
+	if upstreamURL.Host == "" {
+		return nil, fmt.Errorf("Upstream url '%s' has no host", upstream)
+	}
+
+	// OCIDistRepo adds an s to the scheme when verifying TLS
+	upstreamConfig := *config
+	switch upstreamURL.Scheme {
+	case "https":
+		if config.TLSVerify {
+			upstreamURL.Scheme = "ocidist"
+		}
+	case "http":
+		upstreamURL.Scheme = "ocidist"
+		upstreamConfig.TLSVerify = false
+	}
+
+	cache, err := NewBlobCache(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proxy{
+		upstream: upstreamURL,
+		config:   &upstreamConfig,
+		cache:    cache,
+		ttl:      ttl,
+		fills:    map[digest.Digest]*blobFill{},
+	}, nil
+}
+
+// upstreamRepo returns a client for repository name on the upstream registry
+func (p *Proxy) upstreamRepo(name string) (*api.OCIDistRepo, error) {
+	repoURL := &url.URL{
+		Scheme: p.upstream.Scheme,
+		Host:   p.upstream.Host,
+		Path:   "/" + name,
+	}
+	return api.NewOCIDistRepo(repoURL, p.config)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+	}).Debug("Proxy.ServeHTTP() handling request")
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "proxy is read-only")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v2", "/v2/":
+		writeJSON(w, http.StatusOK, "application/json", struct{}{})
+		return
+	case "/v2/_catalog":
+		p.handleCatalog(w, r)
+		return
+	}
+
+	rt, err := parseRoute(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	if !nameRegex.MatchString(rt.Name) {
+		writeError(w, http.StatusBadRequest, ErrCodeNameInvalid, fmt.Sprintf("invalid repository name %q", rt.Name))
+		return
+	}
+
+	switch rt.Kind {
+	case "tags":
+		p.handleTags(w, r, rt)
+	case "manifests":
+		p.handleManifests(w, r, rt)
+	case "blobs":
+		p.handleBlobs(w, r, rt)
+	case "referrers":
+		p.handleReferrers(w, r, rt)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "proxy is read-only")
+	}
+}
+
+func (p *Proxy) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	repo, err := p.upstreamRepo("")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	repos, err := repo.GetRepositories()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, ErrCodeUnsupported, fmt.Sprintf("Failed to list upstream repositories: %s", err))
+		return
+	}
+
+	page, ok := paginate(w, r, repos)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, "application/json", dspec.RepositoryList{Repositories: page})
+}
+
+func (p *Proxy) handleTags(w http.ResponseWriter, r *http.Request, rt *route) {
+	repo, err := p.upstreamRepo(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	tags, err := repo.GetRepoTags()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, ErrCodeUnsupported, fmt.Sprintf("Failed to list upstream tags: %s", err))
+		return
+	}
+
+	page, ok := paginate(w, r, tags)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, "application/json", dspec.TagList{Name: rt.Name, Tags: page})
+}
+
+func (p *Proxy) handleManifests(w http.ResponseWriter, r *http.Request, rt *route) {
+	var mediaType string
+	var content []byte
+	var dgst digest.Digest
+	var err error
+
+	if rt.IsDigest {
+		dgst = digest.Digest(rt.Ref)
+		mediaType, content, err = p.manifestByDigest(rt.Name, dgst)
+	} else {
+		mediaType, content, dgst, err = p.manifestByTag(rt.Name, rt.Ref)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (p *Proxy) manifestByDigest(name string, dgst digest.Digest) (string, []byte, error) {
+	// only digests cached as manifests, other blobs are left to upstream
+	if mediaType, content, err := p.cache.ReadManifest(dgst); err == nil {
+		log.Debugf("Proxy cache hit for manifest %s@%s", name, dgst)
+		return mediaType, content, nil
+	}
+
+	log.Debugf("Proxy cache miss for manifest %s@%s", name, dgst)
+	repo, err := p.upstreamRepo(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mediaType, content, err := repo.GetManifestBytes(dgst.String())
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to fetch manifest '%s' from upstream: %s", dgst, err)
+	}
+	if mediaType == "" {
+		mediaType, _ = detectMediaType(content)
+	}
+
+	if err := p.cache.PutManifest(content, dgst, mediaType); err != nil {
+		return "", nil, err
+	}
+	return mediaType, content, nil
+}
+
+func (p *Proxy) manifestByTag(name, tag string) (string, []byte, digest.Digest, error) {
+	record, err := p.cache.GetTag(name, tag)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", nil, "", err
+	}
+
+	if record != nil && time.Since(record.Fetched) < p.ttl {
+		if content, err := p.cache.ReadBlob(record.Digest); err == nil {
+			log.Debugf("Proxy cache hit for manifest %s:%s", name, tag)
+			return record.MediaType, content, record.Digest, nil
+		}
+	}
+
+	log.Debugf("Proxy revalidating manifest %s:%s with upstream", name, tag)
+	repo, err := p.upstreamRepo(name)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	mediaType, content, err := repo.GetManifestBytes(tag)
+	if err != nil {
+		// serve stale content while upstream is unreachable
+		if record != nil {
+			if cached, cacheErr := p.cache.ReadBlob(record.Digest); cacheErr == nil {
+				log.Warnf("Serving stale manifest %s:%s, upstream failed: %s", name, tag, err)
+				return record.MediaType, cached, record.Digest, nil
+			}
+		}
+		return "", nil, "", fmt.Errorf("Failed to fetch manifest '%s' from upstream: %s", tag, err)
+	}
+	if mediaType == "" {
+		mediaType, _ = detectMediaType(content)
+	}
+
+	dgst := digest.FromBytes(content)
+	if err := p.cache.PutManifest(content, dgst, mediaType); err != nil {
+		return "", nil, "", err
+	}
+
+	newRecord := TagRecord{Digest: dgst, MediaType: mediaType, Fetched: time.Now()}
+	if err := p.cache.PutTag(name, tag, newRecord); err != nil {
+		log.Warnf("Failed to cache tag %s:%s: %s", name, tag, err)
+	}
+
+	return mediaType, content, dgst, nil
+}
+
+func (p *Proxy) handleBlobs(w http.ResponseWriter, r *http.Request, rt *route) {
+	if !rt.IsDigest {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("invalid digest %q", rt.Ref))
+		return
+	}
+	dgst := digest.Digest(rt.Ref)
+
+	// only GET needs the content of a blob that is not cached yet
+	if r.Method == http.MethodHead && !p.cache.HasBlob(dgst) {
+		p.headBlob(w, rt.Name, dgst)
+		return
+	}
+
+	if err := p.fillBlob(rt.Name, dgst); err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, err.Error())
+		return
+	}
+
+	blob, err := p.cache.OpenBlob(dgst)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, err.Error())
+		return
+	}
+	defer blob.Close()
+
+	info, err := blob.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	http.ServeContent(w, r, "", info.ModTime(), blob)
+}
+
+// fillBlob fetches a blob from upstream if it is not already cached,
+// concurrent requests for the same digest wait on a single fetch.
+func (p *Proxy) fillBlob(name string, dgst digest.Digest) error {
+	p.fillLock.Lock()
+	fill, ok := p.fills[dgst]
+	if !ok {
+		fill = &blobFill{}
+		p.fills[dgst] = fill
+	}
+	fill.waiters++
+	p.fillLock.Unlock()
+
+	fill.lock.Lock()
+	defer func() {
+		fill.lock.Unlock()
+		p.fillLock.Lock()
+		fill.waiters--
+		if fill.waiters == 0 {
+			delete(p.fills, dgst)
+		}
+		p.fillLock.Unlock()
+	}()
+
+	if p.cache.HasBlob(dgst) {
+		log.Debugf("Proxy cache hit for blob %s@%s", name, dgst)
+		return nil
+	}
+
+	log.Debugf("Proxy cache miss for blob %s@%s", name, dgst)
+	repo, err := p.upstreamRepo(name)
+	if err != nil {
+		return err
+	}
+
+	reader, err := repo.GetBlobReader(&ispec.Descriptor{Digest: dgst})
+	if err != nil {
+		return fmt.Errorf("Failed to fetch blob '%s' from upstream: %s", dgst, err)
+	}
+	defer reader.Close()
+
+	return p.cache.PutBlob(reader, dgst)
+}
+
+// headBlob answers a HEAD for a blob that is not cached by asking upstream
+func (p *Proxy) headBlob(w http.ResponseWriter, name string, dgst digest.Digest) {
+	repo, err := p.upstreamRepo(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	desc := ispec.Descriptor{Digest: dgst}
+	if err := repo.BlobHead(&desc); err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, fmt.Sprintf("Failed to find blob '%s' upstream: %s", dgst, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", desc.Size))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *Proxy) handleReferrers(w http.ResponseWriter, r *http.Request, rt *route) {
+	if !rt.IsDigest {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("invalid digest %q", rt.Ref))
+		return
+	}
+
+	repo, err := p.upstreamRepo(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	refs, err := repo.GetReferrers(&ispec.Descriptor{Digest: digest.Digest(rt.Ref)})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, ErrCodeUnsupported, fmt.Sprintf("Failed to get upstream referrers: %s", err))
+		return
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+	if artifactType != "" {
+		filtered := []ispec.Descriptor{}
+		for _, desc := range refs.Manifests {
+			if desc.ArtifactType == artifactType {
+				filtered = append(filtered, desc)
+			}
+		}
+		refs.Manifests = filtered
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	if refs.Manifests == nil {
+		refs.Manifests = []ispec.Descriptor{}
+	}
+	writeJSON(w, http.StatusOK, ispec.MediaTypeImageIndex, refs)
+}
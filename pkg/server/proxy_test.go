@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// newTestProxy starts an upstream server and a proxy in front of it,
+// returning the upstream server and the proxy's address
+func newTestProxy(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+	upstream := httptest.NewServer(s)
+	t.Cleanup(upstream.Close)
+
+	p, err := NewProxy(upstream.URL, t.TempDir(), time.Minute, &api.OCIAPIConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %s", err)
+	}
+	proxy := httptest.NewServer(p)
+	t.Cleanup(proxy.Close)
+	return upstream, strings.TrimPrefix(proxy.URL, "http://")
+}
+
+func TestProxyManifestByDigest(t *testing.T) {
+	upstream, host := newTestProxy(t)
+	desc := apitest.PushImage(t, newTestRepo(t, strings.TrimPrefix(upstream.URL, "http://"), "test/img:v1"))
+
+	ociApi := newTestRepo(t, host, "test/img:v1")
+	_, content, err := ociApi.GetManifestBytes(desc.Digest.String())
+	if err != nil {
+		t.Fatalf("Failed to pull manifest through proxy: %s", err)
+	}
+	manifest, _, err := ociApi.GetManifest()
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %s", err)
+	}
+	if _, err := ociApi.GetBlob(&manifest.Config); err != nil {
+		t.Fatalf("Failed to pull config through proxy: %s", err)
+	}
+
+	// the cached config is a blob, not a manifest
+	status, code := errorCode(t, fmt.Sprintf("http://%s/v2/test/img/manifests/%s", host, manifest.Config.Digest))
+	if status != http.StatusNotFound || code != ErrCodeManifestUnknown {
+		t.Errorf("Manifest GET of config returned %d %s, expected 404 %s", status, code, ErrCodeManifestUnknown)
+	}
+
+	// manifests by digest are served from the cache
+	upstream.Close()
+	mediaType, cached, err := ociApi.GetManifestBytes(desc.Digest.String())
+	if err != nil {
+		t.Fatalf("Failed to pull cached manifest: %s", err)
+	}
+	if mediaType != ispec.MediaTypeImageManifest || string(cached) != string(content) {
+		t.Errorf("Got cached %s %q, expected the manifest", mediaType, cached)
+	}
+}
+
+func TestProxyBlobs(t *testing.T) {
+	upstream, host := newTestProxy(t)
+	apitest.PushImage(t, newTestRepo(t, strings.TrimPrefix(upstream.URL, "http://"), "test/img:v1"), []byte("layer content"))
+
+	ociApi := newTestRepo(t, host, "test/img:v1")
+	manifest, _, err := ociApi.GetManifest()
+	if err != nil {
+		t.Fatalf("Failed to pull manifest through proxy: %s", err)
+	}
+	layer := manifest.Layers[0]
+	layerURL := fmt.Sprintf("http://%s/v2/test/img/blobs/%s", host, layer.Digest)
+
+	// HEAD of a blob only upstream has does not fetch it, but has its size
+	resp := doRequest(t, http.MethodHead, layerURL, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != layer.Size {
+		t.Errorf("HEAD of upstream blob returned %d Content-Length %d, expected 200 %d", resp.StatusCode, resp.ContentLength, layer.Size)
+	}
+
+	blob, err := ociApi.GetBlob(&layer)
+	if err != nil {
+		t.Fatalf("Failed to pull layer through proxy: %s", err)
+	}
+	if string(blob) != "layer content" {
+		t.Errorf("Pulled layer %q, expected %q", blob, "layer content")
+	}
+
+	// the cached blob is served without upstream
+	upstream.Close()
+	resp = doRequest(t, http.MethodHead, layerURL, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != layer.Size {
+		t.Errorf("HEAD of cached blob returned %d Content-Length %d, expected 200 %d", resp.StatusCode, resp.ContentLength, layer.Size)
+	}
+
+	missing := fmt.Sprintf("http://%s/v2/test/img/blobs/%s", host, digest.FromString("none"))
+	if resp := doRequest(t, http.MethodHead, missing, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of missing blob returned %d, expected 404", resp.StatusCode)
+	}
+}
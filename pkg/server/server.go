@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrCodeBlobUnknown         = "BLOB_UNKNOWN"
+	ErrCodeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	ErrCodeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	ErrCodeDigestInvalid       = "DIGEST_INVALID"
+	ErrCodeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	ErrCodeManifestInvalid     = "MANIFEST_INVALID"
+	ErrCodeManifestUnknown     = "MANIFEST_UNKNOWN"
+	ErrCodeNameInvalid         = "NAME_INVALID"
+	ErrCodeNameUnknown         = "NAME_UNKNOWN"
+	ErrCodeSizeInvalid         = "SIZE_INVALID"
+	ErrCodeUnsupported         = "UNSUPPORTED"
+)
+
+// distribution-spec repository name, this also keeps names from escaping
+// the server root directory
+var nameRegex = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+var routeRegex = regexp.MustCompile(`^/v2/(.+)/(tags/list|manifests/[^/]+|blobs/uploads/[^/]*|blobs/[^/]+|referrers/[^/]+)$`)
+
+// route is a parsed /v2/ request path
+type route struct {
+	Name     string
+	Kind     string // tags, manifests, blobs, uploads, referrers
+	Ref      string // tag, digest or upload session id
+	IsDigest bool
+}
+
+func parseRoute(path string) (*route, error) {
+	matches := routeRegex.FindStringSubmatch(path)
+	if matches == nil {
+		return nil, fmt.Errorf("Unknown route %q", path)
+	}
+
+	rt := route{Name: matches[1]}
+	endpoint := matches[2]
+	switch {
+	case endpoint == "tags/list":
+		rt.Kind = "tags"
+	case strings.HasPrefix(endpoint, "blobs/uploads/"):
+		rt.Kind = "uploads"
+		rt.Ref = strings.TrimPrefix(endpoint, "blobs/uploads/")
+	default:
+		kind, ref, _ := strings.Cut(endpoint, "/")
+		rt.Kind = kind
+		rt.Ref = ref
+	}
+
+	if rt.Kind != "uploads" && rt.Ref != "" {
+		if _, err := digest.Parse(rt.Ref); err == nil {
+			rt.IsDigest = true
+		}
+	}
+
+	return &rt, nil
+}
+
+// Server implements the OCI distribution-spec /v2/ API on top of a directory
+// of OCI layouts.  Each repository name maps to a layout directory under the
+// server root, e.g. myrepo/myimage => <root>/myrepo/myimage, and each tag
+// maps to a ref name in that layout's index.json.
+type Server struct {
+	layouts *LayoutStore
+}
+
+func NewServer(root string) (*Server, error) {
+	layouts, err := NewLayoutStore(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{layouts: layouts}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+	}).Debug("Server.ServeHTTP() handling request")
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	switch r.URL.Path {
+	case "/v2", "/v2/":
+		s.handleBase(w, r)
+		return
+	case "/v2/_catalog":
+		s.handleCatalog(w, r)
+		return
+	}
+
+	rt, err := parseRoute(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	if !nameRegex.MatchString(rt.Name) {
+		writeError(w, http.StatusBadRequest, ErrCodeNameInvalid, fmt.Sprintf("invalid repository name %q", rt.Name))
+		return
+	}
+
+	switch rt.Kind {
+	case "tags":
+		s.handleTags(w, r, rt)
+	case "manifests":
+		s.handleManifests(w, r, rt)
+	case "blobs":
+		s.handleBlobs(w, r, rt)
+	case "uploads":
+		s.handleUploads(w, r, rt)
+	case "referrers":
+		s.handleReferrers(w, r, rt)
+	default:
+		writeError(w, http.StatusNotFound, ErrCodeUnsupported, fmt.Sprintf("unsupported endpoint %q", r.URL.Path))
+	}
+}
+
+func (s *Server) handleBase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, "application/json", struct{}{})
+}
+
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+
+	repos, err := s.layouts.Repositories()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	page, ok := paginate(w, r, repos)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, "application/json", dspec.RepositoryList{Repositories: page})
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request, rt *route) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+
+	layout, err := s.layouts.Open(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, err.Error())
+		return
+	}
+
+	tags, err := layout.Tags()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	page, ok := paginate(w, r, tags)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, "application/json", dspec.TagList{Name: rt.Name, Tags: page})
+}
+
+// paginate applies the distribution-spec 'n' and 'last' query parameters to
+// a sorted list and sets the Link header if more results are available.
+func paginate(w http.ResponseWriter, r *http.Request, items []string) ([]string, bool) {
+	sort.Strings(items)
+
+	query := r.URL.Query()
+	if last := query.Get("last"); last != "" {
+		idx := sort.SearchStrings(items, last)
+		if idx < len(items) && items[idx] == last {
+			idx++
+		}
+		items = items[idx:]
+	}
+
+	if nStr := query.Get("n"); nStr != "" {
+		n, err := strconv.Atoi(nStr)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupported, fmt.Sprintf("invalid value for n: %q", nStr))
+			return nil, false
+		}
+		if n < len(items) {
+			items = items[:n]
+			if n > 0 {
+				next := *r.URL
+				q := next.Query()
+				q.Set("last", items[n-1])
+				next.RawQuery = q.Encode()
+				w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+			}
+		}
+	}
+
+	if items == nil {
+		items = []string{}
+	}
+	return items, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, contentType string, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, fmt.Sprintf("Failed to marshal response: %s", err))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	log.WithFields(log.Fields{
+		"status":  status,
+		"code":    code,
+		"message": message,
+	}).Debug("Server returning error")
+
+	errResp := dspec.ErrorResponse{
+		Errors: []dspec.ErrorInfo{
+			{Code: code, Message: message},
+		},
+	}
+	content, _ := json.Marshal(errResp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+	w.WriteHeader(status)
+	w.Write(content)
+}
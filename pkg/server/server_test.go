@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/raharper/ocidist/pkg/api"
+	"github.com/raharper/ocidist/pkg/api/apitest"
+
+	dspec "github.com/opencontainers/distribution-spec/specs-go/v1"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// newTestServer serves a fresh root directory, returning its address
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// newTestRepo returns an OCIDistRepo for repoTag on the server at host
+func newTestRepo(t *testing.T, host, repoTag string) api.OCIAPI {
+	t.Helper()
+	ociApi, err := api.NewOCIAPI(fmt.Sprintf("ocidist://%s/%s", host, repoTag), &api.OCIAPIConfig{TLSVerify: false})
+	if err != nil {
+		t.Fatalf("Failed to create repo: %s", err)
+	}
+	return ociApi
+}
+
+func TestPushPull(t *testing.T) {
+	host := newTestServer(t)
+	ociApi := newTestRepo(t, host, "test/img:v1")
+	desc := apitest.PushImage(t, ociApi)
+
+	tags, err := ociApi.GetRepoTags()
+	if err != nil {
+		t.Fatalf("Failed to list tags: %s", err)
+	}
+	if len(tags) != 1 || tags[0] != "v1" {
+		t.Errorf("Got tags %v, expected [v1]", tags)
+	}
+
+	for _, ref := range []string{"v1", desc.Digest.String()} {
+		mediaType, content, err := ociApi.GetManifestBytes(ref)
+		if err != nil {
+			t.Fatalf("Failed to pull manifest %s: %s", ref, err)
+		}
+		if mediaType != ispec.MediaTypeImageManifest || digest.FromBytes(content) != desc.Digest {
+			t.Errorf("Pulled %s %s for %s, expected %s", mediaType, digest.FromBytes(content), ref, desc.Digest)
+		}
+	}
+
+	manifest, _, err := ociApi.GetManifest()
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %s", err)
+	}
+	blob, err := ociApi.GetBlob(&manifest.Layers[0])
+	if err != nil {
+		t.Fatalf("Failed to pull layer: %s", err)
+	}
+	if string(blob) != "layer" {
+		t.Errorf("Pulled layer %q, expected %q", blob, "layer")
+	}
+
+	// a second repository of the server mounts the blobs
+	other := newTestRepo(t, host, "test/other:v1")
+	mounter, ok := other.(api.BlobMounter)
+	if !ok {
+		t.Fatalf("OCIDistRepo does not mount blobs")
+	}
+	if mounted, err := mounter.MountBlob(&manifest.Layers[0], "test/img"); err != nil || !mounted {
+		t.Errorf("Failed to mount layer: %v %v", mounted, err)
+	}
+	if err := other.BlobHead(&manifest.Layers[0]); err != nil {
+		t.Errorf("Mounted layer is missing: %s", err)
+	}
+}
+
+func TestPullMissing(t *testing.T) {
+	host := newTestServer(t)
+	ociApi := newTestRepo(t, host, "test/img:v1")
+
+	if _, _, err := ociApi.GetManifestBytes("v1"); err == nil {
+		t.Errorf("Pulled a manifest from an empty server")
+	}
+	missing := ispec.Descriptor{Digest: digest.FromString("none")}
+	if err := ociApi.BlobHead(&missing); err == nil {
+		t.Errorf("HEAD of a missing blob succeeded")
+	}
+
+	apitest.PushImage(t, ociApi)
+	if _, _, err := ociApi.GetManifestBytes("v2"); err == nil {
+		t.Errorf("Pulled a missing tag")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/v2/test/img/blobs/%s", host, missing.Digest))
+	if err != nil {
+		t.Fatalf("Failed to get blob: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Missing blob returned %d, expected 404", resp.StatusCode)
+	}
+}
+
+func doRequest(t *testing.T, method, url string, headers map[string]string, body []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %s", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %s", method, url, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestChunkedUpload(t *testing.T) {
+	host := newTestServer(t)
+	base := "http://" + host
+	blob := []byte("first chunk, second chunk")
+	dgst := digest.FromBytes(blob)
+
+	resp := doRequest(t, http.MethodPost, base+"/v2/test/img/blobs/uploads/", nil, nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST returned %d, expected 202", resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+
+	resp = doRequest(t, http.MethodPatch, base+location, map[string]string{"Content-Range": "0-12"}, blob[:13])
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Range") != "0-12" {
+		t.Fatalf("PATCH returned %d Range %q, expected 202 0-12", resp.StatusCode, resp.Header.Get("Range"))
+	}
+
+	// a chunk must start where the upload is
+	resp = doRequest(t, http.MethodPatch, base+location, map[string]string{"Content-Range": "0-11"}, blob[13:])
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Out of order PATCH returned %d, expected 416", resp.StatusCode)
+	}
+
+	resp = doRequest(t, http.MethodGet, base+location, nil, nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Range") != "0-12" {
+		t.Errorf("GET of upload returned %d Range %q, expected 204 0-12", resp.StatusCode, resp.Header.Get("Range"))
+	}
+
+	resp = doRequest(t, http.MethodPut, base+location+"?digest="+digest.FromString("wrong").String(), nil, blob[13:])
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PUT with the wrong digest returned %d, expected 400", resp.StatusCode)
+	}
+
+	// the failed PUT ended the session
+	resp = doRequest(t, http.MethodGet, base+location, nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of failed upload returned %d, expected 404", resp.StatusCode)
+	}
+
+	resp = doRequest(t, http.MethodPost, base+"/v2/test/img/blobs/uploads/", nil, nil)
+	location = resp.Header.Get("Location")
+	doRequest(t, http.MethodPatch, base+location, map[string]string{"Content-Range": "0-12"}, blob[:13])
+	resp = doRequest(t, http.MethodPut, base+location+"?digest="+dgst.String(), nil, blob[13:])
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Docker-Content-Digest") != dgst.String() {
+		t.Fatalf("PUT returned %d digest %q, expected 201 %s", resp.StatusCode, resp.Header.Get("Docker-Content-Digest"), dgst)
+	}
+
+	ociApi := newTestRepo(t, host, "test/img:v1")
+	got, err := ociApi.GetBlob(&ispec.Descriptor{Digest: dgst, Size: int64(len(blob))})
+	if err != nil {
+		t.Fatalf("Failed to pull uploaded blob: %s", err)
+	}
+	if string(got) != string(blob) {
+		t.Errorf("Pulled %q, expected %q", got, blob)
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	host := newTestServer(t)
+	ociApi := newTestRepo(t, host, "test/img:v1")
+	subject := apitest.PushImage(t, ociApi)
+
+	if err := ociApi.PutArtifact("sig", "application/vnd.example.sig", []byte("signature")); err != nil {
+		t.Fatalf("Failed to push artifact: %s", err)
+	}
+	if err := ociApi.PutArtifact("sbom", "application/vnd.example.sbom", []byte("sbom")); err != nil {
+		t.Fatalf("Failed to push artifact: %s", err)
+	}
+
+	// the artifacts did not move the tag
+	_, content, err := ociApi.GetManifestBytes("v1")
+	if err != nil {
+		t.Fatalf("Failed to pull tag: %s", err)
+	}
+	if digest.FromBytes(content) != subject.Digest {
+		t.Errorf("Tag v1 moved to %s", digest.FromBytes(content))
+	}
+
+	referrers, err := ociApi.GetReferrers(&subject)
+	if err != nil {
+		t.Fatalf("Failed to get referrers: %s", err)
+	}
+	types := map[string]bool{}
+	for _, ref := range referrers.Manifests {
+		types[ref.ArtifactType] = true
+	}
+	if len(referrers.Manifests) != 2 || !types["application/vnd.example.sig"] || !types["application/vnd.example.sbom"] {
+		t.Errorf("Got referrers %v, expected a sig and an sbom", referrers.Manifests)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/v2/test/img/referrers/%s?artifactType=application/vnd.example.sig", host, subject.Digest))
+	if err != nil {
+		t.Fatalf("Failed to get referrers: %s", err)
+	}
+	defer resp.Body.Close()
+	var filtered ispec.Index
+	if err := json.NewDecoder(resp.Body).Decode(&filtered); err != nil {
+		t.Fatalf("Failed to parse referrers: %s", err)
+	}
+	if resp.Header.Get("OCI-Filters-Applied") != "artifactType" {
+		t.Errorf("Filtered referrers have OCI-Filters-Applied %q", resp.Header.Get("OCI-Filters-Applied"))
+	}
+	if len(filtered.Manifests) != 1 || filtered.Manifests[0].ArtifactType != "application/vnd.example.sig" {
+		t.Errorf("Got filtered referrers %v, expected the sig", filtered.Manifests)
+	}
+
+	// an unknown subject has no referrers
+	unknown := ispec.Descriptor{Digest: digest.FromString("none")}
+	referrers, err = ociApi.GetReferrers(&unknown)
+	if err != nil {
+		t.Fatalf("Failed to get referrers of unknown subject: %s", err)
+	}
+	if len(referrers.Manifests) != 0 {
+		t.Errorf("Unknown subject has referrers %v", referrers.Manifests)
+	}
+}
+
+// errorCode returns the status and first error code of a response
+func errorCode(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	var errResp dspec.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || len(errResp.Errors) == 0 {
+		return resp.StatusCode, ""
+	}
+	return resp.StatusCode, errResp.Errors[0].Code
+}
+
+func TestManifestByDigest(t *testing.T) {
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create server: %s", err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	ociApi := newTestRepo(t, host, "test/img:v1")
+	apitest.PushImage(t, ociApi)
+	manifest, _, err := ociApi.GetManifest()
+	if err != nil {
+		t.Fatalf("Failed to get manifest: %s", err)
+	}
+
+	// blobs are not manifests, even one holding a manifest's JSON
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %s", err)
+	}
+	content = append(content, '\n')
+	payload := apitest.PutBlob(t, ociApi, "application/vnd.example.payload", content)
+	for _, desc := range []ispec.Descriptor{manifest.Config, manifest.Layers[0], payload} {
+		status, code := errorCode(t, fmt.Sprintf("%s/v2/test/img/manifests/%s", srv.URL, desc.Digest))
+		if status != http.StatusNotFound || code != ErrCodeManifestUnknown {
+			t.Errorf("Manifest GET of blob %s returned %d %s, expected 404 %s", desc.Digest, status, code, ErrCodeManifestUnknown)
+		}
+	}
+
+	// a layout written by other tools may only list the index, its
+	// manifests are found through it
+	layout, err := s.layouts.OpenOrCreate("test/idx")
+	if err != nil {
+		t.Fatalf("Failed to create layout: %s", err)
+	}
+	child, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %s", err)
+	}
+	childDigest := digest.FromBytes(child)
+	if _, err := layout.PutBlob(bytes.NewReader(child), childDigest); err != nil {
+		t.Fatalf("Failed to put manifest blob: %s", err)
+	}
+	index, err := json.Marshal(ispec.Index{
+		Versioned: api.ManifestV2,
+		MediaType: ispec.MediaTypeImageIndex,
+		Manifests: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageManifest, Digest: childDigest, Size: int64(len(child))}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal index: %s", err)
+	}
+	if _, err := layout.PutManifest("v1", ispec.MediaTypeImageIndex, index); err != nil {
+		t.Fatalf("Failed to put index: %s", err)
+	}
+
+	idxApi := newTestRepo(t, host, "test/idx:v1")
+	mediaType, content, err := idxApi.GetManifestBytes(childDigest.String())
+	if err != nil {
+		t.Fatalf("Failed to get manifest of index: %s", err)
+	}
+	if mediaType != ispec.MediaTypeImageManifest || digest.FromBytes(content) != childDigest {
+		t.Errorf("Got %s %s, expected manifest %s", mediaType, digest.FromBytes(content), childDigest)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+var sessionRegex = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+// upload sessions are stored as <root>/.uploads/<name hash>-<session id> so a
+// session cannot be resumed against a different repository.
+func (s *Server) uploadPath(name, session string) string {
+	return filepath.Join(s.layouts.UploadsDir(), digest.FromString(name).Encoded()[:16]+"-"+session)
+}
+
+func (s *Server) handleUploads(w http.ResponseWriter, r *http.Request, rt *route) {
+	if rt.Ref == "" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+			return
+		}
+		s.startUpload(w, r, rt)
+		return
+	}
+
+	if !sessionRegex.MatchString(rt.Ref) {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUploadUnknown, fmt.Sprintf("upload %q unknown", rt.Ref))
+		return
+	}
+
+	path := s.uploadPath(rt.Name, rt.Ref)
+	info, err := os.Stat(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUploadUnknown, fmt.Sprintf("upload %q unknown", rt.Ref))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeUploadStatus(w, rt, info.Size(), http.StatusNoContent)
+	case http.MethodPatch:
+		s.patchUpload(w, r, rt, path, info.Size())
+	case http.MethodPut:
+		s.finishUpload(w, r, rt, path, info.Size())
+	case http.MethodDelete:
+		os.Remove(path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+	}
+}
+
+func (s *Server) startUpload(w http.ResponseWriter, r *http.Request, rt *route) {
+	query := r.URL.Query()
+
+	layout, err := s.layouts.OpenOrCreate(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	// cross repository blob mount, fall back to a regular upload session if
+	// the blob is not available
+	if mount := query.Get("mount"); mount != "" {
+		dgst, err := digest.Parse(mount)
+		from := query.Get("from")
+		if err == nil && nameRegex.MatchString(from) {
+			if fromLayout, err := s.layouts.Open(from); err == nil {
+				if err := layout.MountBlob(fromLayout, dgst); err == nil {
+					log.WithFields(log.Fields{
+						"name":   rt.Name,
+						"from":   from,
+						"digest": dgst,
+					}).Debug("Server.startUpload() mounted blob")
+					writeBlobCreated(w, rt, dgst)
+					return
+				}
+			}
+		}
+	}
+
+	// monolithic upload in a single POST
+	if dgstStr := query.Get("digest"); dgstStr != "" {
+		dgst, err := digest.Parse(dgstStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("invalid digest %q", dgstStr))
+			return
+		}
+		if _, err := layout.PutBlob(r.Body, dgst); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, err.Error())
+			return
+		}
+		writeBlobCreated(w, rt, dgst)
+		return
+	}
+
+	sessionBytes := make([]byte, 16)
+	if _, err := rand.Read(sessionBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, fmt.Sprintf("Failed to generate upload session id: %s", err))
+		return
+	}
+	rt.Ref = hex.EncodeToString(sessionBytes)
+
+	fh, err := os.Create(s.uploadPath(rt.Name, rt.Ref))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, fmt.Sprintf("Failed to create upload session: %s", err))
+		return
+	}
+	fh.Close()
+
+	log.WithFields(log.Fields{
+		"name":    rt.Name,
+		"session": rt.Ref,
+	}).Debug("Server.startUpload() created upload session")
+
+	w.Header().Set("OCI-Chunk-Min-Length", "0")
+	writeUploadStatus(w, rt, 0, http.StatusAccepted)
+}
+
+// appendUpload writes the request body to the end of the upload session
+// file, checking Content-Range against the current offset if given.
+func appendUpload(r *http.Request, path string, offset int64) (int64, int, error) {
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+		startStr, _, _ := strings.Cut(contentRange, "-")
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start != offset {
+			return offset, http.StatusRequestedRangeNotSatisfiable, fmt.Errorf("invalid Content-Range %q, upload is at offset %d", contentRange, offset)
+		}
+	}
+
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return offset, http.StatusInternalServerError, err
+	}
+	defer fh.Close()
+
+	written, err := io.Copy(fh, r.Body)
+	if err != nil {
+		return offset, http.StatusInternalServerError, fmt.Errorf("Failed to write upload chunk: %s", err)
+	}
+	return offset + written, 0, nil
+}
+
+func (s *Server) patchUpload(w http.ResponseWriter, r *http.Request, rt *route, path string, offset int64) {
+	size, status, err := appendUpload(r, path, offset)
+	if err != nil {
+		writeError(w, status, ErrCodeBlobUploadInvalid, err.Error())
+		return
+	}
+	writeUploadStatus(w, rt, size, http.StatusAccepted)
+}
+
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request, rt *route, path string, offset int64) {
+	dgst, err := digest.Parse(r.URL.Query().Get("digest"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, fmt.Sprintf("invalid digest %q", r.URL.Query().Get("digest")))
+		return
+	}
+
+	if _, status, err := appendUpload(r, path, offset); err != nil {
+		writeError(w, status, ErrCodeBlobUploadInvalid, err.Error())
+		return
+	}
+
+	layout, err := s.layouts.OpenOrCreate(rt.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+	defer os.Remove(path)
+	defer fh.Close()
+
+	if _, err := layout.PutBlob(fh, dgst); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"name":    rt.Name,
+		"session": rt.Ref,
+		"digest":  dgst,
+	}).Debug("Server.finishUpload() stored blob")
+
+	writeBlobCreated(w, rt, dgst)
+}
+
+func writeUploadStatus(w http.ResponseWriter, rt *route, size int64, status int) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", rt.Name, rt.Ref))
+	w.Header().Set("Docker-Upload-UUID", rt.Ref)
+	end := size - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+func writeBlobCreated(w http.ResponseWriter, rt *route, dgst digest.Digest) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", rt.Name, dgst))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}